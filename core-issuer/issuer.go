@@ -2,17 +2,18 @@ package issuerSDK
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math/big"
+	"os"
+	"sync"
 
 	circuits "github.com/iden3/go-circuits"
 	core "github.com/iden3/go-iden3-core"
 	merkletree "github.com/iden3/go-merkletree-sql/v2"
-	jsonld "github.com/iden3/go-schema-processor/json-ld"
-	"github.com/iden3/go-schema-processor/loaders"
-	"github.com/iden3/go-schema-processor/processor"
 	verifiable "github.com/iden3/go-schema-processor/verifiable"
 
 	"zkSnacks/walletSDK"
@@ -22,40 +23,134 @@ type Issuer struct {
 	Config       *walletSDK.Config                               `json:"config"`
 	Identity     *walletSDK.Identity                             `json:"identity"`
 	IssuedClaims map[string][]walletSDK.Iden3CredentialClaimBody `json:"issued_claims"`
+
+	// mu guards IssuedClaims and its file, issuances of concurrent requests are made one after the other
+	mu sync.Mutex
 }
 
+// issuedClaimsFile keeps the credentials issued by the identity next to account.json, so that updates after a
+// restart continue from the latest version and revoke it.
+const issuedClaimsFile = "./issued_claims.json"
+
 func NewIssuer() *Issuer {
 	config, _ := walletSDK.GetConfig("./config.yaml")
 	identity, _ := walletSDK.GetIdentity("./account.json")
 
-	issuer := Issuer{
+	issuer := &Issuer{
 		Config:       config,
 		Identity:     identity,
 		IssuedClaims: make(map[string][]walletSDK.Iden3CredentialClaimBody),
 	}
+	err := issuer.loadIssuedClaims()
+	if err != nil {
+		log.Fatalf("Failed to load issued claims from the File. Err %s", err)
+	}
+	identity.OnRollback(issuer.dropIssuedClaims)
+
+	return issuer
+}
+
+func (i *Issuer) loadIssuedClaims() error {
+	content, err := ioutil.ReadFile(issuedClaimsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, &i.IssuedClaims)
+}
+
+// saveIssuedClaims writes the issued claims next to the identity, replacing the file atomically. The caller holds
+// the lock.
+func (i *Issuer) saveIssuedClaims() error {
+	content, err := json.MarshalIndent(i.IssuedClaims, "", "	")
+	if err != nil {
+		return err
+	}
+	tmp := issuedClaimsFile + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, issuedClaimsFile)
+}
+
+// dropIssuedClaims removes the claims of a failed state transition. They were signed against a state that is never
+// published, so holders can't use them, and an update goes back to the version it replaced.
+func (i *Issuer) dropIssuedClaims(dropped []walletSDK.ClaimOperation) {
+	nonces := make(map[uint64]bool)
+	for _, operation := range dropped {
+		if operation.Claim != nil {
+			nonces[operation.Claim.GetRevocationNonce()] = true
+		}
+	}
 
-	return &issuer
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	removed := 0
+	for holderID, claims := range i.IssuedClaims {
+		kept := claims[:0]
+		for _, claim := range claims {
+			if claim.Data.Claim != nil && nonces[claim.Data.Claim.GetRevocationNonce()] {
+				removed++
+				continue
+			}
+			kept = append(kept, claim)
+		}
+		i.IssuedClaims[holderID] = kept
+	}
+	if removed == 0 {
+		return
+	}
+	log.Printf("Dropped %d issued claims of a failed state transition.\n", removed)
+	err := i.saveIssuedClaims()
+	if err != nil {
+		log.Println("Error while saving issued claims", err)
+	}
 }
 
 func (i *Issuer) getClaimToAdd(iden3credentialAPI verifiable.Iden3Credential) (*core.Claim, error) {
-	loader := loaders.HTTP{URL: iden3credentialAPI.CredentialSchema.ID}
-	credType := iden3credentialAPI.CredentialSubject["type"].(string)
+	schema, err := i.Config.GetSchemaRegistry().Get(context.Background(), iden3credentialAPI.CredentialSchema.ID)
+	if err != nil {
+		log.Printf("Failed to load schema: %v\n", err)
+		return nil, errors.New("Failed to add claim.")
+	}
+	schemaBytes := schema.Bytes
+
+	// Reject the credential before anything reaches the identity trees
+	err = validateCredential(iden3credentialAPI, schema)
+	if err != nil {
+		return nil, err
+	}
+	credType := iden3credentialAPI.CredentialSchema.Type
 
-	parser := jsonld.Parser{ClaimType: credType,
-		ParsingStrategy: processor.OneFieldPerSlotStrategy}
-	schemaBytes, _, err := loader.Load(context.Background())
+	// Schemas are either json-ld or plain json with index/value slot annotations
+	parser, err := walletSDK.GetSchemaParser(credType, schema.Extension)
 	if err != nil {
+		log.Printf("Failed to get schema parser: %v\n", err)
 		return nil, errors.New("Failed to add claim.")
 	}
 
-	// Careful: This will remove some fields from the iden3credentialAPI.CredentialSubject
+	// The parsers remove id and type from the CredentialSubject and only understand plain identities,
+	// so they get a copy of the subject with the DID replaced by its identity
 	// https://github.com/iden3/go-schema-processor/blob/main/json-ld/parser.go#L69
-	// But for our purpose we don't need them
-	claimToAdd, err := parser.ParseClaim(&iden3credentialAPI, schemaBytes)
+	credentialSubject := make(map[string]interface{}, len(iden3credentialAPI.CredentialSubject))
+	for field, value := range iden3credentialAPI.CredentialSubject {
+		credentialSubject[field] = value
+	}
+	if subjectID, ok := credentialSubject["id"].(string); ok {
+		credentialSubject["id"], _ = walletSDK.NormalizeIdentifier(subjectID)
+	}
+	credentialToParse := iden3credentialAPI
+	credentialToParse.CredentialSubject = credentialSubject
+	claimToAdd, err := parser.ParseClaim(&credentialToParse, schemaBytes)
 	if err != nil {
 		fmt.Println("Error parsing claim: ", err)
 		return nil, errors.New("Failed to parse claim.")
 	}
+	// The parsers don't read the updatable field of the credential
+	claimToAdd.SetFlagUpdatable(iden3credentialAPI.Updatable)
 	id, err := claimToAdd.GetID()
 	fmt.Println("Claim to add: ", id.String())
 	if err != nil {
@@ -65,27 +160,79 @@ func (i *Issuer) getClaimToAdd(iden3credentialAPI verifiable.Iden3Credential) (*
 	return claimToAdd, nil
 }
 
+// ClaimIssuance is one credential to issue. Previous is the version it replaces, nil for a new credential.
+type ClaimIssuance struct {
+	Credential verifiable.Iden3Credential
+	Previous   *walletSDK.Iden3CredentialClaimBody
+}
+
 func (i *Issuer) IssueClaim(iden3credentialAPI verifiable.Iden3Credential) (*circuits.Claim, error) {
-	// Get core claim from Claim API
-	claimToAdd, err := i.getClaimToAdd(iden3credentialAPI)
+	claims, err := i.IssueClaims([]ClaimIssuance{{Credential: iden3credentialAPI}})
 	if err != nil {
-		log.Printf("Failed to add claim: %v\n", err)
 		return nil, err
 	}
+	return claims[0], nil
+}
 
-	id, err := claimToAdd.GetID()
+// UpdateClaim issues a new version of a previously issued updatable credential and revokes the previous version.
+// The holder keeps receiving every version from GetIssuedClaims, the latest one last.
+func (i *Issuer) UpdateClaim(previous walletSDK.Iden3CredentialClaimBody, iden3credentialAPI verifiable.Iden3Credential) (*circuits.Claim, error) {
+	claims, err := i.IssueClaims([]ClaimIssuance{{Credential: iden3credentialAPI, Previous: &previous}})
 	if err != nil {
-		fmt.Println("Subject ID not provided. Currently self claim not supported.")
 		return nil, err
 	}
-	subjectID := id.String()
+	return claims[0], nil
+}
+
+// IssueClaims issues the credentials in a single state transition. Every credential is parsed and validated before
+// the identity changes, so either all of them are issued or none is.
+func (i *Issuer) IssueClaims(issuances []ClaimIssuance) ([]*circuits.Claim, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	operations := make([]walletSDK.ClaimOperation, 0, len(issuances))
+	subjectIDs := make([]string, 0, len(issuances))
+	for _, issuance := range issuances {
+		previous := issuance.Previous
+		if previous != nil {
+			if !previous.Iden3credential.Updatable {
+				return nil, errors.New("Credential is not updatable.")
+			}
+			if issuance.Credential.Version <= previous.Iden3credential.Version {
+				return nil, fmt.Errorf("Credential version %d must be greater than %d.", issuance.Credential.Version, previous.Iden3credential.Version)
+			}
+		}
+
+		// Get core claim from Claim API
+		claimToAdd, err := i.getClaimToAdd(issuance.Credential)
+		if err != nil {
+			log.Printf("Failed to add claim: %v\n", err)
+			return nil, err
+		}
+
+		id, err := claimToAdd.GetID()
+		if err != nil {
+			fmt.Println("Subject ID not provided. Currently self claim not supported.")
+			return nil, err
+		}
+		subjectIDs = append(subjectIDs, id.String())
+
+		operation := walletSDK.ClaimOperation{Claim: claimToAdd}
+		if previous != nil {
+			operation, err = i.Identity.UpdateOperation(previous.Data.Claim, claimToAdd)
+			if err != nil {
+				log.Println("Error while updating claim of identity", err)
+				return nil, errors.New("Failed to update claim.")
+			}
+		}
+		operations = append(operations, operation)
+	}
 
 	// TO-DO: Add Core claim should return infromation about blockchain transaction
 	// https://github.com/iden3/go-schema-processor/blob/main/verifiable/proof.go#L21
 	// It will help to show information about the transaction in the UI
-	err = i.Identity.AddCoreClaim(claimToAdd, i.Config)
+	err := i.Identity.ApplyClaimOperations(operations, i.Config)
 	if err != nil {
-		log.Println("Error while adding claim to identity", err)
+		log.Println("Error while adding claims to identity", err)
 		return nil, errors.New("Failed to add claim.")
 	}
 
@@ -94,6 +241,59 @@ func (i *Issuer) IssueClaim(iden3credentialAPI verifiable.Iden3Credential) (*cir
 		return nil, errors.New("Failed to dump file.")
 	}
 
+	claims := make([]*circuits.Claim, 0, len(issuances))
+	for j, issuance := range issuances {
+		signedClaim, err := i.signClaim(subjectIDs[j], operations[j].Claim, issuance.Credential)
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, signedClaim)
+	}
+
+	err = i.saveIssuedClaims()
+	if err != nil {
+		log.Println("Error while saving issued claims", err)
+		return nil, errors.New("Failed to dump issued claims.")
+	}
+	return claims, nil
+}
+
+// GetLatestIssuedClaim returns the last issued version of a credential type for the holder.
+func (i *Issuer) GetLatestIssuedClaim(holderID string, credentialType string) (*walletSDK.Iden3CredentialClaimBody, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	claims := i.IssuedClaims[holderKey(holderID)]
+	for j := len(claims) - 1; j >= 0; j-- {
+		if claims[j].Iden3credential.CredentialSchema.Type == credentialType {
+			claim := claims[j]
+			return &claim, true
+		}
+	}
+	return nil, false
+}
+
+// GetIssuedClaimByID returns the latest version of an issued credential and the holder it was issued to.
+func (i *Issuer) GetIssuedClaimByID(credentialID string) (*walletSDK.Iden3CredentialClaimBody, string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for holderID, claims := range i.IssuedClaims {
+		for j := len(claims) - 1; j >= 0; j-- {
+			if claims[j].Iden3credential.ID == credentialID {
+				claim := claims[j]
+				return &claim, holderID, true
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// GetRevocationStatus returns the revocation proof of a claim nonce against the issuer's current state.
+func (i *Issuer) GetRevocationStatus(nonce uint64) (*walletSDK.RevocationStatus, error) {
+	return i.Identity.GetRevocationStatus(nonce)
+}
+
+// signClaim builds the signed claim the holder uses in its proofs. The caller holds the lock.
+func (i *Issuer) signClaim(subjectID string, claimToAdd *core.Claim, iden3credentialAPI verifiable.Iden3Credential) (*circuits.Claim, error) {
 	hIndexClaim, hValueClaim, _ := claimToAdd.HiHv()
 	claimHash, err := merkletree.HashElems(hIndexClaim, hValueClaim)
 	if err != nil {
@@ -149,5 +349,16 @@ func (i *Issuer) IssueClaim(iden3credentialAPI verifiable.Iden3Credential) (*cir
 }
 
 func (i *Issuer) GetIssuedClaims(holderID string) []walletSDK.Iden3CredentialClaimBody {
-	return i.IssuedClaims[holderID]
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	claims := i.IssuedClaims[holderKey(holderID)]
+	return append([]walletSDK.Iden3CredentialClaimBody(nil), claims...)
+}
+
+// holderKey returns the plain identity issued claims are stored under, for a DID or plain identity.
+func holderKey(holderID string) string {
+	if id, err := walletSDK.NormalizeIdentifier(holderID); err == nil {
+		return id
+	}
+	return holderID
 }
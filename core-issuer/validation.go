@@ -0,0 +1,94 @@
+package issuerSDK
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iden3/go-schema-processor/utils"
+	verifiable "github.com/iden3/go-schema-processor/verifiable"
+
+	"zkSnacks/walletSDK"
+)
+
+// FieldError reports why a credential subject field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a credential does not match its schema. It lists every invalid field.
+type ValidationError struct {
+	CredentialType string       `json:"credentialType"`
+	Fields         []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return fmt.Sprintf("Invalid %s credential. %s", e.CredentialType, strings.Join(messages, " "))
+}
+
+func (e *ValidationError) add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// validateCredential checks the credential subject against the schema before it is turned into a claim.
+func validateCredential(iden3credentialAPI verifiable.Iden3Credential, schema *walletSDK.SchemaEntry) error {
+	validationErr := &ValidationError{CredentialType: iden3credentialAPI.CredentialSchema.Type}
+	subject := iden3credentialAPI.CredentialSubject
+
+	credType, ok := subject["type"].(string)
+	if !ok || credType == "" {
+		validationErr.add("type", "Credential subject type is missing.")
+		return validationErr
+	}
+	if credType != iden3credentialAPI.CredentialSchema.Type {
+		validationErr.add("type", fmt.Sprintf("Credential subject type %s does not match schema type %s.", credType, iden3credentialAPI.CredentialSchema.Type))
+		return validationErr
+	}
+
+	if id, ok := subject["id"]; ok {
+		idStr, isString := id.(string)
+		if _, err := walletSDK.ParseIdentifier(idStr); !isString || err != nil {
+			validationErr.add("id", "Credential subject id is not a valid identity.")
+		}
+	}
+
+	schemaFields, err := walletSDK.GetSchemaFields(credType, schema.Extension, schema.Bytes)
+	if err != nil {
+		validationErr.add("type", err.Error())
+		return validationErr
+	}
+	known := make(map[string]bool, len(schemaFields))
+	for _, field := range schemaFields {
+		known[field] = true
+		if _, ok := subject[field]; !ok {
+			validationErr.add(field, "Field is required.")
+		}
+	}
+
+	for field, value := range subject {
+		if field == "id" || field == "type" {
+			continue
+		}
+		if !known[field] {
+			validationErr.add(field, "Field is not defined in the schema.")
+			continue
+		}
+		fieldBytes, err := utils.FieldToByteArray(value)
+		if err != nil {
+			validationErr.add(field, "Value must be an integer.")
+			continue
+		}
+		if !utils.CheckDataInField(fieldBytes) {
+			validationErr.add(field, "Value does not fit in the field modulus.")
+		}
+	}
+
+	if len(validationErr.Fields) > 0 {
+		return validationErr
+	}
+	return nil
+}
@@ -11,19 +11,30 @@ import (
 )
 
 func GetConfig(filename string) (*Config, error) {
+	config := new(Config)
+	err := LoadConfigFile(filename, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// LoadConfigFile reads a yaml config file into config, after expanding the ${NAME} and ${NAME:-default}
+// placeholders with environment variables.
+func LoadConfigFile(filename string, config interface{}) error {
 	yfile, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return nil, errors.New("Failed to open the config file.")
+		return errors.Wrap(err, "Failed to open the config file.")
 	}
 
 	yfile = []byte(os.Expand(string(yfile), envMapper))
-	config := new(Config)
 	err = yaml.Unmarshal(yfile, config)
 	if err != nil {
-		return nil, errors.New("Failed to unmarshal the yaml file.")
+		return errors.Wrap(err, "Failed to unmarshal the yaml file.")
 	}
 
-	return config, nil
+	return nil
 }
 
 func GetIdentity(filename string) (*Identity, error) {
@@ -0,0 +1,99 @@
+package walletSDK
+
+import (
+	"crypto/rand"
+	"math/big"
+
+	"github.com/iden3/go-circuits"
+	"github.com/iden3/go-iden3-crypto/babyjub"
+	"github.com/iden3/go-iden3-crypto/constants"
+	"github.com/iden3/go-merkletree-sql/v2"
+	"github.com/pkg/errors"
+)
+
+const authClaimSchemaHex = "ca938857241db9451ea329256b9c06e5"
+
+// AuthProof proves control of an identity: the challenge signed with the key of an auth claim,
+// together with the proofs that the auth claim is in the identity state and not revoked.
+type AuthProof struct {
+	// ID is the DID of the identity, plain identities are accepted as well.
+	ID        string                `json:"id"`
+	Challenge string                `json:"challenge"`
+	Signature babyjub.SignatureComp `json:"signature"`
+	AuthClaim circuits.Claim        `json:"authClaim"`
+}
+
+// NewChallenge returns a random challenge that fits in the field, for holders to sign.
+func NewChallenge() (*big.Int, error) {
+	challenge, err := rand.Int(rand.Reader, constants.Q)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate challenge.")
+	}
+	return challenge, nil
+}
+
+// SignChallenge signs the challenge with the auth claim key of the identity.
+func (identity *Identity) SignChallenge(config *Config, challenge *big.Int) AuthProof {
+	return AuthProof{
+		ID:        identity.DID(config),
+		Challenge: challenge.String(),
+		Signature: identity.PrivateKey.SignPoseidon(challenge).Compress(),
+		AuthClaim: identity.GetUserAuthClaim(),
+	}
+}
+
+// VerifyAuthProof checks that the proof answers the challenge and was signed by a key of the identity.
+// The identity state of the proof must be the genesis state of the identity, its current state on chain or a
+// state replaced less than AcceptedStateTransitionDelay ago.
+func VerifyAuthProof(proof AuthProof, challenge *big.Int, config *Config) error {
+	id, err := ParseIdentifier(proof.ID)
+	if err != nil {
+		return err
+	}
+	if proof.Challenge != challenge.String() {
+		return errors.New("Proof does not answer the challenge.")
+	}
+
+	authClaim := proof.AuthClaim
+	if authClaim.Claim == nil || authClaim.Proof == nil || authClaim.NonRevProof == nil || authClaim.NonRevProof.Proof == nil {
+		return errors.New("Auth claim proofs are missing.")
+	}
+	if authClaim.TreeState.State == nil || authClaim.TreeState.ClaimsRoot == nil || authClaim.TreeState.RevocationRoot == nil || authClaim.TreeState.RootOfRoots == nil {
+		return errors.New("Auth claim tree state is missing.")
+	}
+	schemaHash, _ := authClaim.Claim.GetSchemaHash().MarshalText()
+	if string(schemaHash) != authClaimSchemaHex {
+		return errors.New("Claim is not an auth claim.")
+	}
+
+	// The signature must come from the key in the auth claim
+	slots := authClaim.Claim.RawSlotsAsInts()
+	publicKey := babyjub.PublicKey{X: slots[2], Y: slots[3]}
+	signature, err := proof.Signature.Decompress()
+	if err != nil {
+		return errors.Wrap(err, "Invalid signature.")
+	}
+	if !publicKey.VerifyPoseidon(challenge, signature) {
+		return errors.New("Signature does not match the auth claim key.")
+	}
+
+	// The auth claim must be in the claims tree and not in the revocation tree of the identity state
+	treeState := authClaim.TreeState
+	hIndex, hValue, err := authClaim.Claim.HiHv()
+	if err != nil {
+		return errors.Wrap(err, "Failed to hash auth claim.")
+	}
+	if !authClaim.Proof.Existence || !merkletree.VerifyProof(treeState.ClaimsRoot, authClaim.Proof, hIndex, hValue) {
+		return errors.New("Auth claim is not in the claims tree.")
+	}
+	revNonce := new(big.Int).SetUint64(authClaim.Claim.GetRevocationNonce())
+	if authClaim.NonRevProof.Proof.Existence || !merkletree.VerifyProof(treeState.RevocationRoot, authClaim.NonRevProof.Proof, revNonce, big.NewInt(0)) {
+		return errors.New("Auth claim is revoked.")
+	}
+	state, err := merkletree.HashElems(treeState.ClaimsRoot.BigInt(), treeState.RevocationRoot.BigInt(), treeState.RootOfRoots.BigInt())
+	if err != nil || !state.Equals(treeState.State) {
+		return errors.New("Tree state does not match the identity state.")
+	}
+
+	return VerifyState(config, id, state.BigInt(), AcceptedStateTransitionDelay)
+}
@@ -1,18 +1,24 @@
 package walletSDK
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
-	"log"
 	"math/big"
 	"math/rand"
+	"mime"
 	"net/http"
-	"os"
+	"net/url"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/iden3/go-iden3-auth/loaders"
 	core "github.com/iden3/go-iden3-core"
 	"github.com/iden3/go-iden3-crypto/keccak256"
+	"github.com/iden3/iden3comm/protocol"
+	"github.com/pkg/errors"
 )
 
 type ClaimAPI struct {
@@ -27,14 +33,26 @@ type ClaimAPI struct {
 	ExpirationDate     int64    `json:"expiration_date"`
 }
 
-func CreateIden3ClaimFromAPI(claim ClaimAPI) *core.Claim {
+// MaxSchemaSize bounds the size of a downloaded claim schema.
+const MaxSchemaSize = 1 << 20
+
+var schemaContentTypes = []string{"application/json", "application/ld+json", "text/plain", "application/octet-stream"}
+
+func CreateIden3ClaimFromAPI(claim ClaimAPI, loader loaders.SchemaLoader) (*core.Claim, error) {
 	var schema core.SchemaHash
+	var err error
 	if claim.ClaimSchemaHashHex != "" {
-		schema, _ = core.NewSchemaHashFromHex(claim.ClaimSchemaHashHex)
+		schema, err = core.NewSchemaHashFromHex(claim.ClaimSchemaHashHex)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid claim schema hash.")
+		}
 	} else if claim.ClaimSchema != "" {
-		schema, _ = core.NewSchemaHashFromHex(GetHashFromClaimSchemaURL(claim.ClaimSchema, claim.CredentialType))
+		schema, err = GetSchemaHash(context.Background(), loader, protocol.Schema{URL: claim.ClaimSchema, Type: claim.CredentialType})
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		log.Fatal("No schema hash or schema file provided")
+		return nil, errors.New("No schema hash or schema file provided.")
 	}
 
 	var options []core.Option
@@ -46,54 +64,90 @@ func CreateIden3ClaimFromAPI(claim ClaimAPI) *core.Claim {
 		core.WithValueDataInts(claim.ValueSlotA, claim.ValueSlotB))
 
 	if claim.SubjectID != "" {
-		id, _ := core.IDFromString(claim.SubjectID)
+		id, err := core.IDFromString(claim.SubjectID)
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid claim subject ID.")
+		}
 		options = append(options, core.WithIndexID(id))
 	}
 
-	iden3Claim, _ := core.NewClaim(schema, options...)
-	return iden3Claim
+	return core.NewClaim(schema, options...)
 }
 
-func GetHashFromClaimSchemaURL(schemaHashURL string, credentialType string) string {
-	// create tmp claim schema file
-	claimSchema, err := ioutil.TempFile("tmp", "claim-schema-*.json-ld")
+// GetSchemaHash loads the schema through the loader and returns its iden3 schema hash for the credential type.
+func GetSchemaHash(ctx context.Context, loader loaders.SchemaLoader, schema protocol.Schema) (core.SchemaHash, error) {
+	schemaBytes, _, err := loader.Load(ctx, schema)
 	if err != nil {
-		log.Fatal(err)
+		return core.SchemaHash{}, errors.Wrapf(err, "Failed to load schema %s.", schema.URL)
 	}
-	defer os.Remove(claimSchema.Name())
-	DownloadFileFromURL(schemaHashURL, claimSchema)
-	err = claimSchema.Close()
-	return GetHashFromClaimSchema(claimSchema.Name(), credentialType)
+	return GetHashFromClaimSchemaBytes(schemaBytes, schema.Type), nil
 }
 
-func GetHashFromClaimSchema(file string, credentialType string) string {
-	schemaBytes, _ := os.ReadFile(file)
+// GetHashFromClaimSchemaURL returns the hex encoded schema hash, the form used as key for received claims.
+func GetHashFromClaimSchemaURL(ctx context.Context, loader loaders.SchemaLoader, schemaURL string, credentialType string) (string, error) {
+	sHash, err := GetSchemaHash(ctx, loader, protocol.Schema{URL: schemaURL, Type: credentialType})
+	if err != nil {
+		return "", err
+	}
+	sHashHex, err := sHash.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	return string(sHashHex), nil
+}
 
+func GetHashFromClaimSchemaBytes(schemaBytes []byte, credentialType string) core.SchemaHash {
 	var sHash core.SchemaHash
 	h := keccak256.Hash(schemaBytes, []byte(credentialType))
 	copy(sHash[:], h[len(h)-16:])
-	sHashHex, _ := sHash.MarshalText()
-	fmt.Printf("Got %s hash for the schema %s\n", string(sHashHex), file)
-	return string(sHashHex)
+	return sHash
 }
 
-// DownloadFileFromURL TODO: Put validation on this file path.
-func DownloadFileFromURL(fullURL string, claimSchemaFile *os.File) {
-	client := http.Client{
-		CheckRedirect: func(r *http.Request, via []*http.Request) error {
-			r.URL.Opaque = r.URL.Path
-			return nil
-		},
+// FetchSchema downloads a claim schema and returns it with its format taken from the file extension.
+// Responses that are not a successful JSON document of at most MaxSchemaSize bytes are rejected.
+func FetchSchema(ctx context.Context, schemaURL string) ([]byte, string, error) {
+	u, err := url.Parse(schemaURL)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Invalid schema URL %s.", schemaURL)
 	}
-	// Put content on file
-	resp, err := client.Get(fullURL)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", errors.Errorf("Schema URL scheme %s is not supported.", u.Scheme)
+	}
+	fileName := path.Base(u.Path)
+	extension := fileName[strings.Index(fileName, ".")+1:]
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
 	if err != nil {
-		log.Fatal(err)
+		return nil, "", errors.Wrap(err, "Failed to create schema request.")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Failed to download schema %s.", schemaURL)
 	}
 	defer resp.Body.Close()
 
-	size, err := io.Copy(claimSchemaFile, resp.Body)
-	defer claimSchemaFile.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.Errorf("Schema %s returned status %d.", schemaURL, resp.StatusCode)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !containsString(schemaContentTypes, contentType) {
+		return nil, "", errors.Errorf("Schema %s has unexpected content type %s.", schemaURL, contentType)
+	}
+	if resp.ContentLength > MaxSchemaSize {
+		return nil, "", errors.Errorf("Schema %s is larger than %d bytes.", schemaURL, MaxSchemaSize)
+	}
 
-	fmt.Printf("Downloaded a file %s with size %d\n", claimSchemaFile.Name(), size)
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxSchemaSize+1))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Failed to read schema %s.", schemaURL)
+	}
+	if len(content) > MaxSchemaSize {
+		return nil, "", errors.Errorf("Schema %s is larger than %d bytes.", schemaURL, MaxSchemaSize)
+	}
+	if !json.Valid(content) {
+		return nil, "", errors.Errorf("Schema %s is not a JSON document.", schemaURL)
+	}
+	return content, extension, nil
 }
@@ -0,0 +1,90 @@
+package walletSDK
+
+import (
+	"regexp"
+	"strings"
+
+	core "github.com/iden3/go-iden3-core"
+	"github.com/pkg/errors"
+)
+
+// didRegex accepts did:iden3:<id> and did:iden3:<blockchain>:<network>:<id> for any blockchain and network,
+// unlike core.ParseDID which only knows a fixed list of networks.
+var didRegex = regexp.MustCompile(`^did:iden3:(([a-z0-9]+):([a-z0-9]+):)?([1-9a-km-zA-HJ-NP-Z]{41,42})$`)
+
+// ParseIdentifier returns the identity of a DID. Plain identity strings are accepted for compatibility.
+func ParseIdentifier(identifier string) (*core.ID, error) {
+	id, _, err := parseDID(identifier)
+	return id, err
+}
+
+// parseDID returns the identity of a DID and its blockchain:network part, empty for plain identities
+// and DIDs without a network.
+func parseDID(identifier string) (*core.ID, string, error) {
+	idStr, network := identifier, ""
+	if strings.HasPrefix(identifier, core.DIDSchema+":") {
+		matches := didRegex.FindStringSubmatch(identifier)
+		if matches == nil {
+			return nil, "", errors.Errorf("Invalid DID %s.", identifier)
+		}
+		idStr = matches[4]
+		if matches[1] != "" {
+			network = matches[2] + ":" + matches[3]
+		}
+	}
+	id, err := core.IDFromString(idStr)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "Invalid identifier %s.", identifier)
+	}
+	return &id, network, nil
+}
+
+// NormalizeIdentifier returns the identity string of a DID or plain identity, which is what the iden3 libraries compare.
+func NormalizeIdentifier(identifier string) (string, error) {
+	id, err := ParseIdentifier(identifier)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// SameIdentity tells whether two DIDs or plain identities refer to the same identity. DIDs on different
+// networks are different identities, a plain identity or a DID without a network matches any network.
+func SameIdentity(a, b string) bool {
+	idA, networkA, err := parseDID(a)
+	if err != nil {
+		return false
+	}
+	idB, networkB, err := parseDID(b)
+	if err != nil {
+		return false
+	}
+	if networkA != "" && networkB != "" && networkA != networkB {
+		return false
+	}
+	return idA.String() == idB.String()
+}
+
+// DIDFromID returns the DID of an identity on the network of the config.
+func (config *Config) DIDFromID(id *core.ID) string {
+	did := core.DID{ID: *id}
+	if config.DID.Blockchain != "" {
+		did.Blockchain = core.Blockchain(config.DID.Blockchain)
+		did.NetworkID = core.NetworkID(config.DID.Network)
+	}
+	return did.String()
+}
+
+// ToDID returns the DID of a DID or plain identity on the network of the config.
+func (config *Config) ToDID(identifier string) (string, error) {
+	id, err := ParseIdentifier(identifier)
+	if err != nil {
+		return "", err
+	}
+	return config.DIDFromID(id), nil
+}
+
+// DID returns the DID of the identity on the network of the config.
+func (identity *Identity) DID(config *Config) string {
+	return config.DIDFromID(identity.ID)
+}
@@ -4,10 +4,12 @@ go 1.18
 
 require (
 	github.com/ethereum/go-ethereum v1.10.26
+	github.com/google/uuid v1.2.0
 	github.com/iden3/go-circuits v0.1.1
 	github.com/iden3/go-iden3-auth v0.0.22
 	github.com/iden3/go-iden3-core v0.1.0
 	github.com/iden3/go-iden3-crypto v0.0.13
+	github.com/iden3/go-jwz v0.1.3
 	github.com/iden3/go-merkletree-sql/v2 v2.0.0
 	github.com/iden3/go-rapidsnark/types v0.0.2
 	github.com/iden3/go-schema-processor v0.2.0
@@ -27,9 +29,7 @@ require (
 	github.com/go-ole/go-ole v1.2.1 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/gogo/protobuf v1.3.1 // indirect
-	github.com/google/uuid v1.2.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/iden3/go-jwz v0.1.3 // indirect
 	github.com/iden3/go-merkletree-sql v1.0.1 // indirect
 	github.com/iden3/go-rapidsnark/prover v0.0.5 // indirect
 	github.com/iden3/go-rapidsnark/verifier v0.0.2 // indirect
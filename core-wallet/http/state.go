@@ -0,0 +1,221 @@
+// Package http serves the state and transaction endpoints that the holder and the issuer share. The handlers only
+// use the part of a gin context in Context, so that walletSDK does not depend on a web framework.
+package http
+
+import (
+	"log"
+	"math/big"
+	nethttp "net/http"
+	"strconv"
+
+	core "github.com/iden3/go-iden3-core"
+	"github.com/pkg/errors"
+
+	"zkSnacks/walletSDK"
+)
+
+// Context is the part of a gin context the handlers use.
+type Context interface {
+	Query(key string) string
+	Param(key string) string
+	JSON(code int, obj interface{})
+	IndentedJSON(code int, obj interface{})
+}
+
+type HandlerFunc func(c Context)
+
+// Route is an endpoint for the router of a service.
+type Route struct {
+	Method  string
+	Path    string
+	Handler HandlerFunc
+}
+
+// StateRoutes returns the endpoints about the state of the identity of a service, its transactions and the state of
+// other identities. The watcher may be nil when no websocket endpoint is configured.
+func StateRoutes(config *walletSDK.Config, identity *walletSDK.Identity, watcher *walletSDK.StateWatcher) []Route {
+	return []Route{
+		{nethttp.MethodGet, "/api/v1/state", GetStateData(config, identity)},
+		{nethttp.MethodGet, "/api/v1/state/history", GetStateHistory(config, identity)},
+		{nethttp.MethodGet, "/api/v1/state/transitions/:state", GetStateTransition(config)},
+		{nethttp.MethodGet, "/api/v1/state/status", GetStateStatus(watcher, identity)},
+		{nethttp.MethodGet, "/api/v1/transactions", GetTransactions(config)},
+		{nethttp.MethodGet, "/api/v1/transactions/:id", GetTransaction(config)},
+	}
+}
+
+func GetStateData(config *walletSDK.Config, identity *walletSDK.Identity) HandlerFunc {
+	return func(c Context) {
+		id, err := stateIdentity(c, identity)
+		if err != nil {
+			c.JSON(nethttp.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+			return
+		}
+
+		var stateData *walletSDK.StateData
+		block, timestamp := c.Query("block"), c.Query("timestamp")
+		switch {
+		case block != "":
+			blockN, parseErr := strconv.ParseUint(block, 10, 64)
+			if parseErr != nil {
+				c.JSON(nethttp.StatusBadRequest, map[string]interface{}{"message": "Block must be an unsigned integer."})
+				return
+			}
+			stateData, err = walletSDK.GetStateDataByBlock(config, id, blockN)
+		case timestamp != "":
+			unixTime, parseErr := strconv.ParseUint(timestamp, 10, 64)
+			if parseErr != nil {
+				c.JSON(nethttp.StatusBadRequest, map[string]interface{}{"message": "Timestamp must be an unsigned integer."})
+				return
+			}
+			stateData, err = walletSDK.GetStateDataByTime(config, id, unixTime)
+		default:
+			stateData, err = walletSDK.GetStateData(config, id)
+		}
+		if err != nil {
+			c.JSON(nethttp.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		c.IndentedJSON(nethttp.StatusOK, stateData)
+	}
+}
+
+func GetStateHistory(config *walletSDK.Config, identity *walletSDK.Identity) HandlerFunc {
+	return func(c Context) {
+		id, err := stateIdentity(c, identity)
+		if err != nil {
+			c.JSON(nethttp.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		history, err := walletSDK.GetStateHistory(config, id)
+		if err != nil {
+			c.JSON(nethttp.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		c.IndentedJSON(nethttp.StatusOK, history)
+	}
+}
+
+// GetStateTransition returns when a state was published and replaced. Only a state that was never published is not
+// found, failing to read the contract is a server error.
+func GetStateTransition(config *walletSDK.Config) HandlerFunc {
+	return func(c Context) {
+		state, ok := new(big.Int).SetString(c.Param("state"), 10)
+		if !ok {
+			c.JSON(nethttp.StatusBadRequest, map[string]interface{}{"message": "State must be a decimal integer."})
+			return
+		}
+		info, err := walletSDK.GetTransitionInfo(config, state)
+		if errors.Is(err, walletSDK.ErrStateNotPublished) {
+			c.JSON(nethttp.StatusNotFound, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(nethttp.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		c.IndentedJSON(nethttp.StatusOK, info)
+	}
+}
+
+// stateIdentity returns the identity named by the id query parameter, or the identity of this service.
+func stateIdentity(c Context, identity *walletSDK.Identity) (*core.ID, error) {
+	if c.Query("id") == "" {
+		return identity.ID, nil
+	}
+	return walletSDK.ParseIdentifier(c.Query("id"))
+}
+
+// StartStateWatcher follows the state updates of this service's identity and of the tracked identities.
+// The watcher is nil when no websocket endpoint is configured.
+func StartStateWatcher(config *walletSDK.Config, identity *walletSDK.Identity, tracked ...*core.ID) *walletSDK.StateWatcher {
+	if config.Web3.WSURL == "" {
+		log.Println("Web3 websocket URL is not configured, not watching state updates.")
+		return nil
+	}
+	watcher := walletSDK.NewStateWatcher(config)
+	watcher.OnStateUpdated(func(status walletSDK.IdentityStatus) {
+		if status.ID != identity.ID.String() {
+			log.Printf("State of identity %s updated to %s in block %d.\n", status.ID, status.State, status.BlockNumber)
+			return
+		}
+		// Any state update of this identity comes from one of its transitions, whether it is the latest local one or not
+		manager, err := config.GetTxManager()
+		if err == nil && manager.ConfirmMined(status.TxHash, status.BlockNumber) {
+			log.Printf("State transition mined in block %d with txID: %s.\n", status.BlockNumber, status.TxHash)
+		}
+		if status.State.Cmp(identity.CurrentState().BigInt()) == 0 {
+			log.Printf("Local state %s is published.\n", status.State)
+		}
+	})
+	for _, id := range append([]*core.ID{identity.ID}, tracked...) {
+		err := watcher.Track(id)
+		if err != nil {
+			log.Printf("Failed to read the state of identity %s. Err %s\n", id, err)
+		}
+	}
+	err := watcher.Start(0)
+	if err != nil {
+		log.Printf("Failed to watch state updates. Err %s\n", err)
+		return nil
+	}
+	return watcher
+}
+
+// GetStateStatus returns the latest state seen on chain. For the identity of this service it also tells whether the
+// local state was published yet.
+func GetStateStatus(watcher *walletSDK.StateWatcher, identity *walletSDK.Identity) HandlerFunc {
+	return func(c Context) {
+		if watcher == nil {
+			c.JSON(nethttp.StatusServiceUnavailable, map[string]interface{}{"message": "State updates are not watched."})
+			return
+		}
+		id, err := stateIdentity(c, identity)
+		if err != nil {
+			c.JSON(nethttp.StatusBadRequest, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		status, ok := watcher.GetStatus(id)
+		if id.String() != identity.ID.String() {
+			if !ok {
+				c.JSON(nethttp.StatusNotFound, map[string]interface{}{"message": "No state update seen for this identity."})
+				return
+			}
+			c.IndentedJSON(nethttp.StatusOK, status)
+			return
+		}
+		localState := identity.CurrentState().BigInt()
+		c.IndentedJSON(nethttp.StatusOK, map[string]interface{}{
+			"status":     status,
+			"localState": localState,
+			"published":  ok && status.State.Cmp(localState) == 0,
+		})
+	}
+}
+
+func GetTransactions(config *walletSDK.Config) HandlerFunc {
+	return func(c Context) {
+		manager, err := config.GetTxManager()
+		if err != nil {
+			c.JSON(nethttp.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		c.IndentedJSON(nethttp.StatusOK, manager.GetTransactions(c.Query("status")))
+	}
+}
+
+func GetTransaction(config *walletSDK.Config) HandlerFunc {
+	return func(c Context) {
+		manager, err := config.GetTxManager()
+		if err != nil {
+			c.JSON(nethttp.StatusInternalServerError, map[string]interface{}{"message": err.Error()})
+			return
+		}
+		tx, ok := manager.GetTransaction(c.Param("id"))
+		if !ok {
+			c.JSON(nethttp.StatusNotFound, map[string]interface{}{"message": "Transaction not found."})
+			return
+		}
+		c.IndentedJSON(nethttp.StatusOK, tx)
+	}
+}
@@ -0,0 +1,149 @@
+package walletSDK
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/google/uuid"
+	"github.com/iden3/go-circuits"
+	core "github.com/iden3/go-iden3-core"
+	"github.com/iden3/go-jwz"
+	"github.com/iden3/go-rapidsnark/types"
+	verifiable "github.com/iden3/go-schema-processor/verifiable"
+	"github.com/iden3/iden3comm"
+	"github.com/iden3/iden3comm/packers"
+	"github.com/iden3/iden3comm/protocol"
+	"github.com/pkg/errors"
+)
+
+// authProvingMethod proves JWZ tokens with the auth circuit through snarkjs, like every other proof of the wallet.
+// Tokens are verified the same way as with the groth16 auth method of go-jwz.
+type authProvingMethod struct {
+	config *Config
+}
+
+func (m authProvingMethod) Alg() string {
+	return jwz.ProvingMethodGroth16AuthInstance.Alg()
+}
+
+func (m authProvingMethod) CircuitID() string {
+	return jwz.ProvingMethodGroth16AuthInstance.CircuitID()
+}
+
+func (m authProvingMethod) Verify(messageHash []byte, proof *types.ZKProof, verificationKey []byte) error {
+	return jwz.ProvingMethodGroth16AuthInstance.Verify(messageHash, proof, verificationKey)
+}
+
+func (m authProvingMethod) Prove(inputs, provingKey, wasm []byte) (*types.ZKProof, error) {
+	return GenerateZkProof(m.config.Circuits.Path+string(circuits.AuthCircuitID), toJSON(inputs), m.config)
+}
+
+// PackZKPMessage packs an iden3comm message as a JWZ token, proving with the auth claim that the identity sent it.
+func (identity *Identity) PackZKPMessage(message interface{}, config *Config) ([]byte, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal message.")
+	}
+	packer := packers.NewZKPPacker(authProvingMethod{config: config}, identity.prepareAuthInputs, nil, nil, nil, nil)
+	token, err := packer.Pack(payload, packers.ZKPPackerParams{SenderID: identity.ID})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to pack message as JWZ.")
+	}
+	return token, nil
+}
+
+// UnpackZKPMessage verifies a JWZ token against the auth circuit verification key and the sender state,
+// and returns the message it carries. The sender of the message may be a DID or a plain identity.
+func UnpackZKPMessage(envelope []byte, config *Config) (*iden3comm.BasicMessage, error) {
+	verificationKey, err := ioutil.ReadFile(config.Circuits.Path + string(circuits.AuthCircuitID) + "/verification_key.json")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read auth verification key.")
+	}
+
+	token, err := jwz.Parse(string(envelope))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse JWZ message.")
+	}
+	if circuits.CircuitID(token.CircuitID) != circuits.AuthCircuitID {
+		return nil, errors.Errorf("Message was packed with unsupported circuit %s.", token.CircuitID)
+	}
+	isValid, err := token.Verify(verificationKey)
+	if err != nil || !isValid {
+		return nil, errors.New("Message proof is invalid.")
+	}
+
+	var outputs circuits.AuthPubSignals
+	err = token.ParsePubSignals(&outputs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal auth public signals.")
+	}
+	err = VerifyState(config, outputs.UserID, outputs.UserState.BigInt(), AcceptedStateTransitionDelay)
+	if err != nil {
+		return nil, err
+	}
+
+	var message iden3comm.BasicMessage
+	err = json.Unmarshal(token.GetPayload(), &message)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal message.")
+	}
+	// The iden3comm packer only accepts plain identities as sender, so the sender is checked here
+	sender, err := ParseIdentifier(message.From)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid message sender.")
+	}
+	if sender.String() != outputs.UserID.String() {
+		return nil, errors.Errorf("Sender %s of the message did not create the proof.", message.From)
+	}
+	return &message, nil
+}
+
+// NewCredentialFetchRequest builds the request for one of the credentials of an offer.
+func (identity *Identity) NewCredentialFetchRequest(config *Config, offer protocol.CredentialsOfferMessage, credentialID string) protocol.CredentialFetchRequestMessage {
+	return protocol.CredentialFetchRequestMessage{
+		ID:       uuid.New().String(),
+		Typ:      packers.MediaTypeZKPMessage,
+		Type:     protocol.CredentialFetchRequestMessageType,
+		ThreadID: offer.ThreadID,
+		Body: protocol.CredentialFetchRequestMessageBody{
+			ID: credentialID,
+		},
+		From: identity.DID(config),
+		To:   offer.From,
+	}
+}
+
+// CredentialFromClaimBody returns the credential with the signed claim as its proof, which is how
+// the issuer hands out claims in iden3comm issuance messages.
+func CredentialFromClaimBody(claim Iden3CredentialClaimBody) verifiable.Iden3Credential {
+	credential := claim.Iden3credential
+	credential.Proof = claim.Data
+	return credential
+}
+
+// ClaimBodyFromCredential reads back the signed claim carried in the proof of an issued credential.
+func ClaimBodyFromCredential(credential verifiable.Iden3Credential) (*Iden3CredentialClaimBody, error) {
+	proof, err := json.Marshal(credential.Proof)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal credential proof.")
+	}
+	var data circuits.Claim
+	err = json.Unmarshal(proof, &data)
+	if err != nil || data.Claim == nil {
+		return nil, errors.New("Credential proof does not contain a signed claim.")
+	}
+	credential.Proof = nil
+	return &Iden3CredentialClaimBody{Iden3credential: credential, Data: data}, nil
+}
+
+func (identity *Identity) prepareAuthInputs(hash []byte, id *core.ID, circuitID circuits.CircuitID) ([]byte, error) {
+	challenge := new(big.Int).SetBytes(hash)
+	authInputs := circuits.AuthInputs{
+		ID:        identity.ID,
+		AuthClaim: identity.GetUserAuthClaim(),
+		Signature: identity.PrivateKey.SignPoseidon(challenge),
+		Challenge: challenge,
+	}
+	return authInputs.InputsMarshal()
+}
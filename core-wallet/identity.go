@@ -7,10 +7,10 @@ import (
 	"log"
 	"math/big"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/iden3/go-circuits"
-	"github.com/iden3/go-iden3-auth/loaders"
 	"github.com/iden3/go-iden3-auth/pubsignals"
 	core "github.com/iden3/go-iden3-core"
 	"github.com/iden3/go-iden3-crypto/babyjub"
@@ -28,16 +28,50 @@ type Iden3CredentialClaimBody struct {
 	Data            circuits.Claim             `json:"data"`
 }
 
+// ClaimOperation is one change of the identity: it adds a claim to the claims tree and revokes nonces in the
+// revocation tree. A state transition applies one or more operations. Operations are kept in order so the trees
+// can be replayed.
+type ClaimOperation struct {
+	Claim        *core.Claim `json:"claim,omitempty"`
+	RevokeNonces []uint64    `json:"revoke_nonces,omitempty"`
+}
+
+// RevocationStatus is the revocation tree proof for a claim nonce, with the issuer tree state it was generated at.
+type RevocationStatus struct {
+	RevocationNonce uint64             `json:"revocation_nonce"`
+	Revoked         bool               `json:"revoked"`
+	Issuer          circuits.TreeState `json:"issuer"`
+	MTP             *merkletree.Proof  `json:"mtp"`
+}
+
 type Identity struct {
-	ID             *core.ID                            `json:"id"`
-	IDS            *merkletree.Hash                    `json:"identity_state"`
-	PrivateKey     babyjub.PrivateKey                  `json:"private_key"`
-	AuthClaim      *core.Claim                         `json:"authClaim"`
-	Claims         []*core.Claim                       `json:"claims"`
-	Clt            *merkletree.MerkleTree              `json:"clt"`
-	Ret            *merkletree.MerkleTree              `json:"ret"`
-	Rot            *merkletree.MerkleTree              `json:"rot"`
-	ReceivedClaims map[string]Iden3CredentialClaimBody `json:"received_claims"`
+	ID             *core.ID                              `json:"id"`
+	IDS            *merkletree.Hash                      `json:"identity_state"`
+	PrivateKey     babyjub.PrivateKey                    `json:"private_key"`
+	AuthClaim      *core.Claim                           `json:"authClaim"`
+	Claims         []*core.Claim                         `json:"claims"`
+	Operations     []ClaimOperation                      `json:"operations,omitempty"`
+	Clt            *merkletree.MerkleTree                `json:"clt"`
+	Ret            *merkletree.MerkleTree                `json:"ret"`
+	Rot            *merkletree.MerkleTree                `json:"rot"`
+	ReceivedClaims map[string]Iden3CredentialClaimBody   `json:"received_claims"`
+	ClaimHistory   map[string][]Iden3CredentialClaimBody `json:"claim_history,omitempty"`
+
+	// lock is held while a state transition changes the trees, readers on other goroutines use CurrentState
+	lock sync.RWMutex
+	// pendingTransitions are the transitions whose transaction is not mined yet, oldest first
+	pendingTransitions []pendingTransition
+	watchOnce          sync.Once
+	// rollbackHandlers are told about the operations dropped with a failed transition
+	rollbackHandlers []func(dropped []ClaimOperation)
+}
+
+// pendingTransition is what the identity looked like before a transition, to go back to when its transaction fails.
+type pendingTransition struct {
+	txID       string
+	operations int
+	claims     int
+	ids        *merkletree.Hash
 }
 
 type Config struct {
@@ -54,13 +88,48 @@ type Config struct {
 	Web3 struct {
 		StateTransition string   `yaml:"stateTransition"`
 		URL             string   `yaml:"url"`
+		WSURL           string   `yaml:"wsURL"`
 		PrivateKey      string   `yaml:"privateKey"`
 		ChainID         *big.Int `yaml:"chainID"`
+		Signer          struct {
+			Type         string `yaml:"type"`
+			Keystore     string `yaml:"keystore"`
+			Password     string `yaml:"password"`
+			PasswordFile string `yaml:"passwordFile"`
+			URL          string `yaml:"url"`
+			Address      string `yaml:"address"`
+		} `yaml:"signer"`
+		Fees struct {
+			MaxFeePerGas         *big.Int      `yaml:"maxFeePerGas"`
+			MaxPriorityFeePerGas *big.Int      `yaml:"maxPriorityFeePerGas"`
+			GasLimit             uint64        `yaml:"gasLimit"`
+			BumpPercent          int           `yaml:"bumpPercent"`
+			ResubmitAfter        time.Duration `yaml:"resubmitAfter"`
+			MaxAttempts          int           `yaml:"maxAttempts"`
+		} `yaml:"fees"`
 	} `yaml:"web3"`
 	UI struct {
 		StaticDir string `yaml:"staticDir"`
 		HtmlDir   string `yaml:"htmlDir"`
 	} `yaml:"ui"`
+	DID struct {
+		Blockchain string `yaml:"blockchain"`
+		Network    string `yaml:"network"`
+		// Resolvers are the DID resolver endpoints trusted for the services of identities registered elsewhere
+		Resolvers []string `yaml:"resolvers"`
+	} `yaml:"did"`
+	Schemas struct {
+		Dir      string `yaml:"dir"`
+		CacheDir string `yaml:"cacheDir"`
+		Offline  bool   `yaml:"offline"`
+	} `yaml:"schemas"`
+	Credentials []CredentialTemplate `yaml:"credentials"`
+
+	schemaRegistry     *SchemaRegistry
+	schemaRegistryOnce sync.Once
+	txManager          *TxManager
+	txManagerErr       error
+	txManagerOnce      sync.Once
 }
 
 func NewIdentity() (*Identity, error) {
@@ -69,7 +138,7 @@ func NewIdentity() (*Identity, error) {
 
 	ctx := context.Background()
 
-	authSchemaHash, _ := core.NewSchemaHashFromHex("ca938857241db9451ea329256b9c06e5")
+	authSchemaHash, _ := core.NewSchemaHashFromHex(authClaimSchemaHex)
 	authClaim, _ := core.NewClaim(authSchemaHash,
 		core.WithIndexDataInts(babyJubjubPubKey.X, babyJubjubPubKey.Y),
 		core.WithRevocationNonce(rand.Uint64()))
@@ -120,13 +189,7 @@ func LoadIdentityFromFile(file string) (*Identity, error) {
 	}
 
 	ctx := context.Background()
-	identity.Clt, _ = merkletree.NewMerkleTree(ctx, memory.NewMemoryStorage(), 32)
-	identity.Ret, _ = merkletree.NewMerkleTree(ctx, memory.NewMemoryStorage(), 32)
-	identity.Rot, _ = merkletree.NewMerkleTree(ctx, memory.NewMemoryStorage(), 32)
-
-	hIndex, hValue, _ := identity.AuthClaim.HiHv()
-
-	err = identity.Clt.Add(ctx, hIndex, hValue)
+	err = identity.resetTrees(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed while adding auth claim from JSON File")
 	}
@@ -140,19 +203,16 @@ func LoadIdentityFromFile(file string) (*Identity, error) {
 		return nil, errors.Errorf("ID differs while recreating it from json file. Generated id is %s but in file it is %s", id.String(), identity.ID.String())
 	}
 
-	// TODO: Handle Ret Tree
-	for _, claim := range identity.Claims {
-		// Before updating the claims tree, add the claims tree root at Genesis state to the Roots tree.
-		err := identity.Rot.Add(ctx, identity.Clt.Root().BigInt(), big.NewInt(0))
-		if err != nil {
-			return nil, errors.Wrap(err, "Error while adding the root of the Clt to the Rot In the load file func.")
-		}
-		hIndex, hValue, _ := claim.HiHv()
-		err = identity.Clt.Add(ctx, hIndex, hValue)
-		if err != nil {
-			return nil, errors.Wrap(err, "Failed while adding claims to the Clt from JSON File.")
+	// Identity files written before operations were recorded only list the added claims.
+	if len(identity.Operations) == 0 {
+		for _, claim := range identity.Claims {
+			identity.Operations = append(identity.Operations, ClaimOperation{Claim: claim})
 		}
 	}
+	err = identity.replayOperations(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while replaying claim operations from JSON File.")
+	}
 
 	if identity.GetIDS().String() != identity.IDS.String() {
 		return nil, errors.Errorf("IDS differs while recreating it from json file. Generated IDS is %s but in file it is %s.", identity.GetIDS().String(), identity.IDS.String())
@@ -160,8 +220,115 @@ func LoadIdentityFromFile(file string) (*Identity, error) {
 	return identity, nil
 }
 
+// resetTrees replaces the trees with empty ones holding only the auth claim, the genesis state of the identity.
+func (identity *Identity) resetTrees(ctx context.Context) error {
+	identity.Clt, _ = merkletree.NewMerkleTree(ctx, memory.NewMemoryStorage(), 32)
+	identity.Ret, _ = merkletree.NewMerkleTree(ctx, memory.NewMemoryStorage(), 32)
+	identity.Rot, _ = merkletree.NewMerkleTree(ctx, memory.NewMemoryStorage(), 32)
+
+	hIndex, hValue, _ := identity.AuthClaim.HiHv()
+	return identity.Clt.Add(ctx, hIndex, hValue)
+}
+
+// replayOperations applies the recorded operations to the trees in order.
+func (identity *Identity) replayOperations(ctx context.Context) error {
+	for _, operation := range identity.Operations {
+		err := identity.applyOperation(ctx, operation)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (identity *Identity) AddCoreClaim(claimToAdd *core.Claim, config *Config) error {
+	return identity.transitState([]ClaimOperation{{Claim: claimToAdd}}, config)
+}
+
+// ApplyClaimOperations applies the operations in a single state transition. Either all of them are in the new
+// state or, when the transition fails, none of them is and the identity keeps its previous state.
+func (identity *Identity) ApplyClaimOperations(operations []ClaimOperation, config *Config) error {
+	if len(operations) == 0 {
+		return errors.New("No claim operations to apply.")
+	}
+	return identity.transitState(operations, config)
+}
+
+// UpdateOperation returns the operation that replaces a claim with a newer revision of it. The new revision must
+// have a higher version and its own revocation nonce. The version is in the index of the claim, so a revision can't
+// keep the leaf of the previous one: updates add the new revision as a new leaf and revoke the previous revision in
+// the same state transition.
+func (identity *Identity) UpdateOperation(oldClaim, newClaim *core.Claim) (ClaimOperation, error) {
+	if newClaim.GetVersion() <= oldClaim.GetVersion() {
+		return ClaimOperation{}, errors.Errorf("New claim version %d must be greater than %d.", newClaim.GetVersion(), oldClaim.GetVersion())
+	}
+	if newClaim.GetRevocationNonce() == oldClaim.GetRevocationNonce() {
+		return ClaimOperation{}, errors.New("New claim revision must have its own revocation nonce.")
+	}
+
+	oldIndex, err := oldClaim.HIndex()
+	if err != nil {
+		return ClaimOperation{}, errors.Wrap(err, "Failed to get index of the old claim.")
+	}
+	if _, _, _, err := identity.Clt.Get(context.Background(), oldIndex); err != nil {
+		return ClaimOperation{}, errors.Wrap(err, "Claim to update does not exist in Clt.")
+	}
+
+	operation := ClaimOperation{
+		Claim:        newClaim,
+		RevokeNonces: []uint64{oldClaim.GetRevocationNonce()},
+	}
+	return operation, nil
+}
+
+// GetRevocationStatus returns the proof that the nonce is (or is not) in the revocation tree at the current state.
+func (identity *Identity) GetRevocationStatus(nonce uint64) (*RevocationStatus, error) {
+	proof, _, err := identity.Ret.GenerateProof(context.Background(), new(big.Int).SetUint64(nonce), identity.Ret.Root())
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate revocation MTP.")
+	}
+	return &RevocationStatus{
+		RevocationNonce: nonce,
+		Revoked:         proof.Existence,
+		Issuer:          identity.GetTreeState(),
+		MTP:             proof,
+	}, nil
+}
+
+// applyOperation writes the operation into the trees, after saving the current claims tree root in the Roots tree.
+func (identity *Identity) applyOperation(ctx context.Context, operation ClaimOperation) error {
+	// A transition that only revokes keeps the claims tree root, which is then already in the Roots tree.
+	err := identity.Rot.Add(ctx, identity.Clt.Root().BigInt(), big.NewInt(0))
+	if err != nil && err != merkletree.ErrEntryIndexAlreadyExists {
+		return errors.Wrap(err, "Error while adding the root of the Clt to the Rot.")
+	}
+
+	if operation.Claim != nil {
+		hIndex, hValue, err := operation.Claim.HiHv()
+		if err != nil {
+			return errors.Wrap(err, "Failed to hash claim.")
+		}
+		err = identity.Clt.Add(ctx, hIndex, hValue)
+		if err != nil {
+			return errors.Wrap(err, "Error while writing the claim to Clt.")
+		}
+	}
+
+	for _, nonce := range operation.RevokeNonces {
+		err = identity.Ret.Add(ctx, new(big.Int).SetUint64(nonce), big.NewInt(0))
+		if err != nil {
+			return errors.Wrapf(err, "Error while adding revocation nonce %d to Ret.", nonce)
+		}
+	}
+	return nil
+}
+
+// transitState applies the operations and proves the transition from the old to the new identity state on chain.
+// When any step fails the trees are rebuilt from the operations recorded before.
+func (identity *Identity) transitState(operations []ClaimOperation, config *Config) (err error) {
 	ctx := context.Background()
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
 
 	authClaim := identity.AuthClaim
 	authClaimIndex, _ := authClaim.HIndex()
@@ -177,20 +344,24 @@ func (identity *Identity) AddCoreClaim(claimToAdd *core.Claim, config *Config) e
 	isOldStateGenesis, _ := identity.IsAtGenesisState()
 	oldTreeState := identity.GetTreeState()
 
-	// Before updating the claims tree, add the claims tree root at Genesis state to the Roots tree.
-	err := identity.Rot.Add(ctx, identity.Clt.Root().BigInt(), big.NewInt(0))
-	if err != nil {
-		return errors.Wrap(err, "Error while adding the root of the Clt to the Rot.")
-	}
-
-	hIndex, hValue, _ := claimToAdd.HiHv()
+	appliedOperations, appliedClaims, oldIDS := len(identity.Operations), len(identity.Claims), identity.IDS
+	defer func() {
+		if err != nil {
+			identity.rollback(ctx, appliedOperations, appliedClaims, oldIDS)
+		}
+	}()
 
-	err = identity.Clt.Add(ctx, hIndex, hValue)
-	if err != nil {
-		return errors.Wrap(err, "Error while adding the new claim to Clt.")
+	for _, operation := range operations {
+		err = identity.applyOperation(ctx, operation)
+		if err != nil {
+			return err
+		}
+		identity.Operations = append(identity.Operations, operation)
+		if operation.Claim != nil {
+			// Add the claim to our array
+			identity.Claims = append(identity.Claims, operation.Claim)
+		}
 	}
-	// Add the claim to our array
-	identity.Claims = append(identity.Claims, claimToAdd)
 
 	// Fetch the new Identity State
 	newState := identity.GetIDS()
@@ -227,24 +398,128 @@ func (identity *Identity) AddCoreClaim(claimToAdd *core.Claim, config *Config) e
 	if err != nil {
 		return errors.Wrap(err, "Errored while submitting transaction to blockchain.")
 	}
-	log.Printf("Add Claim successful. Submitted to change state on blockchain with txID: %s.\n", transaction.Hash().String())
+	log.Printf("State transition successful. Submitted to change state on blockchain with txID: %s and nonce %d.\n", transaction.Hash(), transaction.Nonce)
+	identity.pendingTransitions = append(identity.pendingTransitions, pendingTransition{
+		txID:       transaction.ID,
+		operations: appliedOperations,
+		claims:     appliedClaims,
+		ids:        oldIDS,
+	})
+	identity.watchOnce.Do(func() {
+		if manager, err := config.GetTxManager(); err == nil {
+			manager.OnDone(identity.transitionDone)
+		}
+	})
 	return nil
 }
 
+// transitionDone forgets a mined transition. When its transaction failed, the contract rejects every later transition
+// from its state, so the identity goes back to the state before it and drops the transitions made on top of it.
+func (identity *Identity) transitionDone(tx PendingTx) {
+	dropped, handlers := identity.dropTransition(tx)
+	// The handlers run without the lock, they may start new transitions
+	if len(dropped) == 0 {
+		return
+	}
+	for _, handler := range handlers {
+		handler(dropped)
+	}
+}
+
+// dropTransition does the work of transitionDone under the lock. It returns the dropped operations and the handlers
+// to tell about them.
+func (identity *Identity) dropTransition(tx PendingTx) ([]ClaimOperation, []func(dropped []ClaimOperation)) {
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
+	for i, pending := range identity.pendingTransitions {
+		if pending.txID != tx.ID {
+			continue
+		}
+		if tx.Status == TxMined {
+			identity.pendingTransitions = append(identity.pendingTransitions[:i], identity.pendingTransitions[i+1:]...)
+			return nil, nil
+		}
+		dropped := append([]ClaimOperation(nil), identity.Operations[pending.operations:]...)
+		identity.rollback(context.Background(), pending.operations, pending.claims, pending.ids)
+		identity.pendingTransitions = identity.pendingTransitions[:i]
+		log.Printf("State transition %s failed: %s Dropped %d claim operations, the identity is back at state %s.\n", tx.ID, tx.Error, len(dropped), pending.ids)
+		err := DumpIdentity(identity)
+		if err != nil {
+			log.Printf("Failed to save the identity after the failed transition. Err %s\n", err)
+		}
+		return dropped, identity.rollbackHandlers
+	}
+	return nil, nil
+}
+
+// OnRollback registers a handler that is called with the operations dropped when a submitted transition fails, so
+// that what was made from them, like signed claims, can be dropped as well.
+func (identity *Identity) OnRollback(handler func(dropped []ClaimOperation)) {
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
+	identity.rollbackHandlers = append(identity.rollbackHandlers, handler)
+}
+
+// rollback drops the operations and claims recorded after the given counts and rebuilds the trees without them.
+func (identity *Identity) rollback(ctx context.Context, operations int, claims int, ids *merkletree.Hash) {
+	identity.Operations = identity.Operations[:operations]
+	identity.Claims = identity.Claims[:claims]
+	identity.IDS = ids
+	err := identity.resetTrees(ctx)
+	if err == nil {
+		err = identity.replayOperations(ctx)
+	}
+	if err != nil {
+		log.Printf("Failed to roll back the identity trees: %s\n", err)
+	}
+}
+
 func (identity *Identity) AddClaim(claim ClaimAPI, config *Config) error {
-	claimToAdd := CreateIden3ClaimFromAPI(claim)
+	claimToAdd, err := CreateIden3ClaimFromAPI(claim, config.GetSchemaRegistry())
+	if err != nil {
+		return errors.Wrap(err, "Failed to create claim from API.")
+	}
 	return identity.AddCoreClaim(claimToAdd, config)
 }
 
+// AddClaimsFromIssuer stores the latest version of every received claim. Older revisions are kept in ClaimHistory.
 func (identity *Identity) AddClaimsFromIssuer(claims []Iden3CredentialClaimBody) error {
 	// TODO: Better key for looking up Claims
 	for _, claim := range claims {
 		schemaHash, _ := claim.Data.Claim.GetSchemaHash().MarshalText()
-		identity.ReceivedClaims[string(schemaHash)] = claim
+		key := string(schemaHash)
+		if current, ok := identity.ReceivedClaims[key]; ok {
+			if current.Data.Claim.GetRevocationNonce() == claim.Data.Claim.GetRevocationNonce() {
+				continue
+			}
+			if current.Data.Claim.GetVersion() > claim.Data.Claim.GetVersion() {
+				identity.addClaimHistory(key, claim)
+				continue
+			}
+			identity.addClaimHistory(key, current)
+		}
+		identity.ReceivedClaims[key] = claim
 	}
 	return nil
 }
 
+// GetClaimHistory returns the superseded revisions of the claims received for a schema hash.
+func (identity *Identity) GetClaimHistory(schemaHash string) []Iden3CredentialClaimBody {
+	return identity.ClaimHistory[schemaHash]
+}
+
+func (identity *Identity) addClaimHistory(key string, claim Iden3CredentialClaimBody) {
+	if identity.ClaimHistory == nil {
+		identity.ClaimHistory = make(map[string][]Iden3CredentialClaimBody)
+	}
+	for _, old := range identity.ClaimHistory[key] {
+		if old.Data.Claim.GetRevocationNonce() == claim.Data.Claim.GetRevocationNonce() {
+			return
+		}
+	}
+	identity.ClaimHistory[key] = append(identity.ClaimHistory[key], claim)
+}
+
 // TODO: Add a functionality to add self claims as well.
 func (identity *Identity) GetStoredClaims() []Iden3CredentialClaimBody {
 	claims := []Iden3CredentialClaimBody{}
@@ -254,9 +529,46 @@ func (identity *Identity) GetStoredClaims() []Iden3CredentialClaimBody {
 	return claims
 }
 
+// ProofRequest proves every proof request of the scope of the request that the identity has a claim for. The scope
+// ID is the challenge of its proof, which only tells the proofs of one request apart since scope IDs start over with
+// every request. The response is bound to the request by its ID and thread ID in the signed JWZ, and the verifier
+// accepts a single response per session.
 func (identity *Identity) ProofRequest(request protocol.AuthorizationRequestMessage, config *Config) (*protocol.AuthorizationResponseMessage, error) {
-	rules := request.Body.Scope[0].Rules
-	jsonStr, err := json.Marshal(rules["query"])
+	var scope []protocol.ZeroKnowledgeProofResponse
+	for _, proofRequest := range request.Body.Scope {
+		proofResponse, err := identity.proveScope(proofRequest, config)
+		if err != nil {
+			log.Printf("Skipping proof request %d. Err %s\n", proofRequest.ID, err)
+			continue
+		}
+		scope = append(scope, *proofResponse)
+	}
+	if len(scope) == 0 {
+		return nil, errors.New("Requested claim does not exists in the wallet.")
+	}
+
+	resp := protocol.AuthorizationResponseMessage{
+		ID:       request.ID,
+		Typ:      packers.MediaTypeZKPMessage,
+		Type:     protocol.AuthorizationResponseMessageType,
+		ThreadID: request.ThreadID,
+		Body: protocol.AuthorizationMessageResponseBody{
+			Message: request.Body.Message,
+			Scope:   scope,
+		},
+		From: identity.DID(config),
+		To:   request.From,
+	}
+	return &resp, nil
+}
+
+func (identity *Identity) proveScope(proofRequest protocol.ZeroKnowledgeProofRequest, config *Config) (*protocol.ZeroKnowledgeProofResponse, error) {
+	// TODO: Support the MTP circuit as well
+	circuitName := circuits.AtomicQuerySigCircuitID
+	if circuits.CircuitID(proofRequest.CircuitID) != circuitName {
+		return nil, errors.Errorf("Circuit %s is not supported.", proofRequest.CircuitID)
+	}
+	jsonStr, err := json.Marshal(proofRequest.Rules["query"])
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to convert query into jsonStr.")
 	}
@@ -264,53 +576,43 @@ func (identity *Identity) ProofRequest(request protocol.AuthorizationRequestMess
 	if err := json.Unmarshal(jsonStr, &query); err != nil {
 		return nil, errors.Wrap(err, "Failed to typecast rule to pubsignals.Query.")
 	}
-	parsedQuery, _ := ValidateAndGetCircuitsQuery(query, context.Background(), loaders.DefaultSchemaLoader{IpfsURL: ""})
+	parsedQuery, err := ValidateAndGetCircuitsQuery(query, context.Background(), config.GetSchemaRegistry())
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to validate proof request query.")
+	}
 
-	challenge := new(big.Int).SetInt64(1)
-	schemaHash := GetHashFromClaimSchemaURL(query.Schema.URL, query.Schema.Type)
+	challenge := new(big.Int).SetUint64(uint64(proofRequest.ID))
+	schemaHash, err := GetHashFromClaimSchemaURL(context.Background(), config.GetSchemaRegistry(), query.Schema.URL, query.Schema.Type)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get hash of the requested schema.")
+	}
 
-	// TODO: Get Dynamic circuit name from proof request
-	circuitName := circuits.AtomicQuerySigCircuitID
-	if val, ok := identity.ReceivedClaims[schemaHash]; ok {
-		atomicInputs := circuits.AtomicQuerySigInputs{
-			ID:               identity.ID,
-			AuthClaim:        identity.GetUserAuthClaim(),
-			Challenge:        challenge,
-			Signature:        identity.PrivateKey.SignPoseidon(challenge),
-			CurrentTimeStamp: time.Now().Unix(),
-			Claim:            val.Data,
-			Query:            *parsedQuery,
-		}
-		inputBytes, err := atomicInputs.InputsMarshal()
-		if err != nil {
-			return nil, errors.Wrapf(err, "Error during marshalling of %s circuit inputs.", circuitName)
-		}
-		proof, err := GenerateZkProof(config.Circuits.Path+"credentialAtomicQuerySig", toJSON(inputBytes), config)
-		if err != nil {
-			return nil, errors.Wrap(err, "Error while generating proof using snarkJS.")
-		}
-		resp := protocol.AuthorizationResponseMessage{
-			ID:       request.ID,
-			Typ:      packers.MediaTypePlainMessage,
-			Type:     protocol.AuthorizationResponseMessageType,
-			ThreadID: request.ThreadID,
-			Body: protocol.AuthorizationMessageResponseBody{
-				Message: request.Body.Message,
-				Scope: []protocol.ZeroKnowledgeProofResponse{
-					{
-						ID:        1,
-						CircuitID: string(circuits.AtomicQuerySigCircuitID),
-						ZKProof:   *proof,
-					},
-				},
-			},
-			From: identity.ID.String(),
-			To:   request.From,
-		}
-		return &resp, nil
-	} else {
+	val, ok := identity.ReceivedClaims[schemaHash]
+	if !ok {
 		return nil, errors.New("Requested claim does not exists in the wallet.")
 	}
+	atomicInputs := circuits.AtomicQuerySigInputs{
+		ID:               identity.ID,
+		AuthClaim:        identity.GetUserAuthClaim(),
+		Challenge:        challenge,
+		Signature:        identity.PrivateKey.SignPoseidon(challenge),
+		CurrentTimeStamp: time.Now().Unix(),
+		Claim:            val.Data,
+		Query:            *parsedQuery,
+	}
+	inputBytes, err := atomicInputs.InputsMarshal()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error during marshalling of %s circuit inputs.", circuitName)
+	}
+	proof, err := GenerateZkProof(config.Circuits.Path+"credentialAtomicQuerySig", toJSON(inputBytes), config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error while generating proof using snarkJS.")
+	}
+	return &protocol.ZeroKnowledgeProofResponse{
+		ID:        proofRequest.ID,
+		CircuitID: string(circuitName),
+		ZKProof:   *proof,
+	}, nil
 }
 
 func (identity *Identity) GetTreeState() circuits.TreeState {
@@ -322,6 +624,13 @@ func (identity *Identity) GetTreeState() circuits.TreeState {
 	}
 }
 
+// CurrentState returns the identity state without racing a state transition that is changing the trees.
+func (identity *Identity) CurrentState() *merkletree.Hash {
+	identity.lock.RLock()
+	defer identity.lock.RUnlock()
+	return identity.GetIDS()
+}
+
 func (identity *Identity) GetIDS() *merkletree.Hash {
 	state, _ := merkletree.HashElems(
 		identity.Clt.Root().BigInt(),
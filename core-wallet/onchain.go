@@ -7,7 +7,6 @@ import (
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	types2 "github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 	core "github.com/iden3/go-iden3-core"
 	"github.com/iden3/go-rapidsnark/types"
@@ -22,7 +21,7 @@ func getClient(config *Config) (*ethclient.Client, error) {
 	return client, nil
 }
 
-func TransitState(config *Config, id *core.ID, proof *types.ZKProof) (*types2.Transaction, error) {
+func getStateContract(config *Config) (*state.State, error) {
 	client, err := getClient(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create Eth Client")
@@ -30,9 +29,23 @@ func TransitState(config *Config, id *core.ID, proof *types.ZKProof) (*types2.Tr
 
 	address := common.HexToAddress(config.Web3.StateTransition)
 	instance, err := state.NewState(address, client)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create NewState")
+	}
+	return instance, nil
+}
+
+// TransitState sends the state transition through the transaction manager of the config, which sets the nonce and
+// fees and replaces the transaction while it is stuck.
+func TransitState(config *Config, id *core.ID, proof *types.ZKProof) (*PendingTx, error) {
+	instance, err := getStateContract(config)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to instance of State Contract")
 	}
+	manager, err := config.GetTxManager()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create transaction manager")
+	}
 	pub, err := stringsToArrayBigInt(proof.PubSignals)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed while converting pubsignal to BigInt")
@@ -65,16 +78,9 @@ func TransitState(config *Config, id *core.ID, proof *types.ZKProof) (*types2.Tr
 		c[index] = tmp
 	}
 
-	privateKey, err := crypto.HexToECDSA(config.Web3.PrivateKey)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed while deriving privateKey")
-	}
-
-	auth, err := bind.NewKeyedTransactorWithChainID(privateKey, config.Web3.ChainID)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed while creating NewKeyedTransactorWithChainID")
-	}
-	tx, err := instance.TransitState(auth, pub[0], pub[1], pub[2], len(pub[3].Bytes()) != 0, a, b, c)
+	tx, err := manager.Send(func(opts *bind.TransactOpts) (*types2.Transaction, error) {
+		return instance.TransitState(opts, pub[0], pub[1], pub[2], len(pub[3].Bytes()) != 0, a, b, c)
+	})
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed while calling TransitState func")
 	}
@@ -98,3 +104,22 @@ func GetCurrentState(config *Config, id *core.ID) (*big.Int, error) {
 	}
 	return currentState, nil
 }
+
+// StateData is the latest state of an identity published on chain, with the block it was published in.
+type StateData struct {
+	BlockNumber    uint64
+	BlockTimestamp uint64
+	State          *big.Int
+}
+
+func GetStateData(config *Config, id *core.ID) (*StateData, error) {
+	instance, err := getStateContract(config)
+	if err != nil {
+		return nil, err
+	}
+	blockN, timestamp, currentState, err := instance.GetStateDataById(nil, id.BigInt())
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get State data from smart contract")
+	}
+	return &StateData{BlockNumber: blockN, BlockTimestamp: timestamp, State: currentState}, nil
+}
@@ -206,7 +206,12 @@ func VerifyZkProof(circuitPath string, zkp *types.ZKProof) error {
 	return nil
 }
 
+// ValidateAndGetCircuitsQuery checks that the schema of the query loads and has the queried field, and that the field
+// has exactly one supported predicate. It returns the query in the form of the circuit inputs.
 func ValidateAndGetCircuitsQuery(q pubsignals.Query, ctx context.Context, loader loaders.SchemaLoader) (*circuits.Query, error) {
+	if q.Schema.URL == "" || q.Schema.Type == "" {
+		return nil, errors.New("Schema url and type are required.")
+	}
 	schemaBytes, ext, err := loader.Load(ctx, q.Schema)
 	if err != nil {
 		return nil, errors.Wrap(err, "Can't load schema for request query.")
@@ -226,21 +231,48 @@ func ValidateAndGetCircuitsQuery(q pubsignals.Query, ctx context.Context, loader
 
 func prepareProcessor(claimType, ext string) (*processor.Processor, error) {
 	pr := &processor.Processor{}
-	var parser processor.Parser
+	parser, err := GetSchemaParser(claimType, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	return processor.InitProcessorOptions(pr, processor.WithParser(parser)), nil
+}
+
+// GetSchemaParser returns the go-schema-processor parser for a schema format (json or json-ld).
+func GetSchemaParser(claimType, ext string) (processor.Parser, error) {
 	switch ext {
 	case jsonExt:
-		parser = jsonSuite.Parser{ParsingStrategy: processor.OneFieldPerSlotStrategy}
+		return jsonSuite.Parser{ParsingStrategy: processor.OneFieldPerSlotStrategy}, nil
 	case jsonldExt:
-		parser = jsonldSuite.Parser{ClaimType: claimType, ParsingStrategy: processor.OneFieldPerSlotStrategy}
+		return jsonldSuite.Parser{ClaimType: claimType, ParsingStrategy: processor.OneFieldPerSlotStrategy}, nil
 	default:
 		return nil, errors.Errorf("Process suite for schema format %s is not supported.", ext)
 	}
+}
 
-	return processor.InitProcessorOptions(pr, processor.WithParser(parser)), nil
+// DetectSchemaFormat returns the format of a schema from its content: documents with an @context are json-ld,
+// documents with slot annotations under properties are json. Otherwise the extension of the schema file is kept.
+func DetectSchemaFormat(ext string, schemaBytes []byte) string {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaBytes, &schema); err != nil {
+		return ext
+	}
+	if _, ok := schema["@context"]; ok {
+		return jsonldExt
+	}
+	if properties, ok := schema["properties"].(map[string]interface{}); ok {
+		_, hasIndex := properties["index"]
+		_, hasValue := properties["value"]
+		if hasIndex && hasValue {
+			return jsonExt
+		}
+	}
+	return ext
 }
 
 func parseRequest(req map[string]interface{}, schema []byte, pr *processor.Processor) (*circuits.Query, error) {
-	if req == nil {
+	if len(req) == 0 {
 		return &circuits.Query{
 			SlotIndex: 0,
 			Values:    nil,
@@ -274,7 +306,7 @@ func parseFieldPredicate(fieldPredicate map[string]interface{}, err error) ([]*b
 		var ok bool
 		operator, ok = circuits.QueryOperators[op]
 		if !ok {
-			return nil, 0, errors.New("Query operator is not supported.")
+			return nil, 0, errors.Errorf("Query operator %s is not supported.", op)
 		}
 
 		values, err = getValuesAsArray(v)
@@ -300,8 +332,8 @@ func extractQueryFields(req map[string]interface{}) (fieldName string, fieldPred
 		if !ok {
 			return "", nil, errors.New("Failed cast type map[string]interface.")
 		}
-		if len(fieldPredicate) > 1 {
-			return "", nil, errors.New("Multiple predicates for one field not supported.")
+		if len(fieldPredicate) != 1 {
+			return "", nil, errors.Errorf("Field %s must have exactly one predicate.", field)
 		}
 		break
 	}
@@ -318,7 +350,11 @@ func getValuesAsArray(v interface{}) ([]*big.Int, error) {
 	case []interface{}:
 		values = make([]*big.Int, len(value))
 		for i, item := range value {
-			values[i] = new(big.Int).SetInt64(int64(item.(float64)))
+			number, ok := item.(float64)
+			if !ok {
+				return nil, errors.Errorf("Unsupported value type %T.", item)
+			}
+			values[i] = new(big.Int).SetInt64(int64(number))
 		}
 	default:
 		return nil, errors.Errorf("Unsupported values type %T.", v)
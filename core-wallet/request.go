@@ -0,0 +1,104 @@
+package walletSDK
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/iden3/iden3comm/protocol"
+	"github.com/pkg/errors"
+)
+
+const (
+	// RequestSignatureHeader holds the signature of the verifier over the body of a verification request
+	RequestSignatureHeader = "X-Request-Signature"
+	// VerifierInfoPath is where a verifier publishes its DID and the key that signs its requests
+	VerifierInfoPath = "/api/v1/verifier"
+	// DeepLinkScheme is the scheme of the links that verifiers put in their QR codes
+	DeepLinkScheme = "iden3comm"
+)
+
+// VerifierInfo is what a verifier publishes about itself.
+type VerifierInfo struct {
+	ID         string `json:"id"`
+	Host       string `json:"host"`
+	SigningKey struct {
+		Alg       string `json:"alg"`
+		Crv       string `json:"crv"`
+		PublicKey string `json:"publicKey"`
+	} `json:"signingKey"`
+}
+
+// ErrUnverifiedRequest is returned for requests that can't be checked: unsigned requests, and requests of verifiers
+// the trusted resolvers publish no signing key for.
+var ErrUnverifiedRequest = errors.New("Request is not signed with a key of a trusted verifier.")
+
+// VerifyRequestSignature checks the signature of a verification request with the key of the verifier that made it.
+// The key is taken from the DID document of the verifier at the trusted resolvers, never from the host that served
+// the request. It returns the info of the verifier, so that the holder can be shown who is asking.
+func VerifyRequestSignature(resolver *Resolver, body []byte, signature string, request protocol.AuthorizationRequestMessage) (*VerifierInfo, error) {
+	if signature == "" {
+		return nil, errors.Wrap(ErrUnverifiedRequest, "Request has no signature")
+	}
+	document, err := resolver.Resolve(request.From)
+	if err != nil {
+		return nil, errors.Wrapf(ErrUnverifiedRequest, "Failed to resolve the verifier %s: %s", request.From, err)
+	}
+	publicKey, ok := document.GetEd25519Key()
+	if !ok {
+		return nil, errors.Wrapf(ErrUnverifiedRequest, "Verifier %s has no signing key", request.From)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(publicKey, body, sig) {
+		return nil, errors.New("Invalid signature of the verification request.")
+	}
+
+	info := &VerifierInfo{ID: request.From}
+	info.SigningKey.Alg = "EdDSA"
+	info.SigningKey.Crv = "Ed25519"
+	info.SigningKey.PublicKey = base64.RawURLEncoding.EncodeToString(publicKey)
+	if endpoint, ok := document.GetService(VerifierInfoServiceType); ok {
+		if u, err := url.Parse(endpoint); err == nil {
+			info.Host = u.Scheme + "://" + u.Host
+		}
+	}
+	return info, nil
+}
+
+// ParseRequestLink returns the URL to fetch the verification request from, for the holder senderID. The link is an
+// iden3comm://?request_uri= deep link, the request URL itself, or the JSON of the QR codes of older verifiers.
+func ParseRequestLink(link string, senderID string) (string, error) {
+	link = strings.TrimSpace(link)
+	if strings.HasPrefix(link, "{") {
+		var payload struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal([]byte(link), &payload); err != nil {
+			return "", errors.Wrap(err, "Invalid request link.")
+		}
+		link = payload.URL
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid request link.")
+	}
+	if u.Scheme == DeepLinkScheme {
+		requestURI := u.Query().Get("request_uri")
+		if requestURI == "" {
+			return "", errors.New("Deep link has no request_uri.")
+		}
+		u, err = url.Parse(requestURI)
+		if err != nil {
+			return "", errors.Wrap(err, "Invalid request_uri of the deep link.")
+		}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", errors.Errorf("Unsupported request link %s.", link)
+	}
+	query := u.Query()
+	query.Set("senderId", senderID)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,283 @@
+package walletSDK
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	core "github.com/iden3/go-iden3-core"
+	"github.com/pkg/errors"
+)
+
+const (
+	DIDContext = "https://www.w3.org/ns/did/v1"
+
+	RevocationStatusServiceType = "Iden3RevocationStatusV1"
+	AgentServiceType            = "Iden3CommServiceV1"
+	ChallengeServiceType        = "Iden3AuthChallengeV1"
+	VerifierInfoServiceType     = "Iden3VerifierInfoV1"
+
+	// DIDResolverPath is where the services publish the documents of their resolver, followed by the DID
+	DIDResolverPath = "/api/v1/identifiers/"
+
+	// JSONWebKeyType is the type of the verification methods that hold their key as a JWK
+	JSONWebKeyType = "JsonWebKey2020"
+)
+
+type DIDService struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// JWK is a public key in JSON Web Key format. Only OKP keys, like Ed25519 keys, are used.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// DIDVerificationMethod is a key the identity signs with, outside of its identity state.
+type DIDVerificationMethod struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Controller   string `json:"controller"`
+	PublicKeyJwk JWK    `json:"publicKeyJwk"`
+}
+
+// IdentityState is the state of an identity on chain. An identity that never published a state is at its genesis state.
+type IdentityState struct {
+	Value          string `json:"value,omitempty"`
+	BlockNumber    uint64 `json:"blockNumber,omitempty"`
+	BlockTimestamp uint64 `json:"blockTimestamp,omitempty"`
+	Published      bool   `json:"published"`
+	Genesis        bool   `json:"genesis"`
+}
+
+type DIDDocument struct {
+	Context            []string                `json:"@context"`
+	ID                 string                  `json:"id"`
+	VerificationMethod []DIDVerificationMethod `json:"verificationMethod,omitempty"`
+	Service            []DIDService            `json:"service,omitempty"`
+	State              IdentityState           `json:"identityState"`
+}
+
+type didService struct {
+	fragment        string
+	serviceType     string
+	serviceEndpoint string
+}
+
+type didKey struct {
+	fragment string
+	key      JWK
+}
+
+// Resolver resolves did:iden3 identifiers to DID documents. The state comes from the state contract and the
+// service endpoints and keys from the ones registered with the resolver, or for other identities from the resolver
+// endpoints trusted in the config.
+type Resolver struct {
+	config *Config
+	client *http.Client
+
+	mu       sync.RWMutex
+	services map[string][]didService
+	keys     map[string][]didKey
+}
+
+func NewResolver(config *Config) *Resolver {
+	return &Resolver{
+		config:   config,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		services: make(map[string][]didService),
+		keys:     make(map[string][]didKey),
+	}
+}
+
+// AddService adds a service endpoint to the document of an identity. The fragment names the service within the document.
+func (resolver *Resolver) AddService(identifier, fragment, serviceType, endpoint string) error {
+	id, err := ParseIdentifier(identifier)
+	if err != nil {
+		return err
+	}
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	resolver.services[id.String()] = append(resolver.services[id.String()], didService{
+		fragment:        fragment,
+		serviceType:     serviceType,
+		serviceEndpoint: endpoint,
+	})
+	return nil
+}
+
+// AddVerificationMethod adds a key to the document of an identity. The fragment names the key within the document.
+func (resolver *Resolver) AddVerificationMethod(identifier, fragment string, key JWK) error {
+	id, err := ParseIdentifier(identifier)
+	if err != nil {
+		return err
+	}
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+	resolver.keys[id.String()] = append(resolver.keys[id.String()], didKey{fragment: fragment, key: key})
+	return nil
+}
+
+func (resolver *Resolver) Resolve(identifier string) (*DIDDocument, error) {
+	id, err := ParseIdentifier(identifier)
+	if err != nil {
+		return nil, err
+	}
+	did := identifier
+	if did == id.String() {
+		did = resolver.config.DIDFromID(id)
+	}
+
+	state, err := resolveIdentityState(resolver.config, id)
+	if err != nil {
+		return nil, err
+	}
+	document := &DIDDocument{
+		Context: []string{DIDContext},
+		ID:      did,
+		State:   *state,
+	}
+
+	resolver.mu.RLock()
+	services := resolver.services[id.String()]
+	keys := resolver.keys[id.String()]
+	resolver.mu.RUnlock()
+	for _, key := range keys {
+		document.VerificationMethod = append(document.VerificationMethod, DIDVerificationMethod{
+			ID:           did + "#" + key.fragment,
+			Type:         JSONWebKeyType,
+			Controller:   did,
+			PublicKeyJwk: key.key,
+		})
+	}
+	for _, service := range services {
+		document.Service = append(document.Service, DIDService{
+			ID:              did + "#" + service.fragment,
+			Type:            service.serviceType,
+			ServiceEndpoint: service.serviceEndpoint,
+		})
+	}
+	if len(services) == 0 && len(keys) == 0 {
+		remote, err := resolver.remoteDocument(did)
+		if err != nil {
+			return nil, err
+		}
+		if remote != nil {
+			document.VerificationMethod = remote.VerificationMethod
+			document.Service = remote.Service
+		}
+	}
+	return document, nil
+}
+
+// ResolveService returns the endpoint of the first service of the given type in the document of the identifier.
+func (resolver *Resolver) ResolveService(identifier, serviceType string) (string, error) {
+	document, err := resolver.Resolve(identifier)
+	if err != nil {
+		return "", err
+	}
+	endpoint, ok := document.GetService(serviceType)
+	if !ok {
+		return "", errors.Errorf("%s has no %s service.", document.ID, serviceType)
+	}
+	return endpoint, nil
+}
+
+// remoteDocument asks the trusted resolver endpoints in order for the document of an identity, nil when none of
+// them knows it. Only the services and keys are taken from it, the state always comes from the state contract.
+func (resolver *Resolver) remoteDocument(did string) (*DIDDocument, error) {
+	for _, endpoint := range resolver.config.DID.Resolvers {
+		document, err := resolver.fetchDocument(endpoint, did)
+		if err != nil {
+			return nil, err
+		}
+		if document != nil {
+			return document, nil
+		}
+	}
+	return nil, nil
+}
+
+// fetchDocument returns the document of the DID at a resolver endpoint, nil when the endpoint does not know it.
+func (resolver *Resolver) fetchDocument(endpoint, did string) (*DIDDocument, error) {
+	resp, err := resolver.client.Get(strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(did))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to resolve %s at %s.", did, endpoint)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Resolver %s returned %s for %s.", endpoint, resp.Status, did)
+	}
+	var document DIDDocument
+	err = json.NewDecoder(resp.Body).Decode(&document)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to decode the document of %s.", did)
+	}
+	if !SameIdentity(document.ID, did) {
+		return nil, errors.Errorf("Resolver %s returned the document of %s for %s.", endpoint, document.ID, did)
+	}
+	// A resolver only answers for the identities registered with it
+	if len(document.Service) == 0 && len(document.VerificationMethod) == 0 {
+		return nil, nil
+	}
+	return &document, nil
+}
+
+// GetEd25519Key returns the first Ed25519 key of the document.
+func (document *DIDDocument) GetEd25519Key() (ed25519.PublicKey, bool) {
+	for _, method := range document.VerificationMethod {
+		jwk := method.PublicKeyJwk
+		if method.Type != JSONWebKeyType || jwk.Kty != "OKP" || jwk.Crv != "Ed25519" {
+			continue
+		}
+		key, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err == nil && len(key) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(key), true
+		}
+	}
+	return nil, false
+}
+
+// GetService returns the endpoint of the first service of the given type in the document.
+func (document *DIDDocument) GetService(serviceType string) (string, bool) {
+	for _, service := range document.Service {
+		if service.Type == serviceType {
+			return service.ServiceEndpoint, true
+		}
+	}
+	return "", false
+}
+
+func resolveIdentityState(config *Config, id *core.ID) (*IdentityState, error) {
+	stateData, err := GetStateData(config, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to resolve the state of the identity.")
+	}
+	// The contract returns a zero state for identities that never transited their state
+	if stateData.State == nil || stateData.State.Sign() == 0 {
+		return &IdentityState{Genesis: true}, nil
+	}
+	genesis, err := checkGenesisStateID(id.BigInt(), stateData.State)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to check the genesis state of the identity.")
+	}
+	return &IdentityState{
+		Value:          stateData.State.String(),
+		BlockNumber:    stateData.BlockNumber,
+		BlockTimestamp: stateData.BlockTimestamp,
+		Published:      true,
+		Genesis:        genesis,
+	}, nil
+}
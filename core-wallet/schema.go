@@ -0,0 +1,253 @@
+package walletSDK
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iden3/iden3comm/protocol"
+	"github.com/pkg/errors"
+)
+
+// SchemaEntry is a claim schema known to the registry.
+type SchemaEntry struct {
+	URL       string `json:"url"`
+	Extension string `json:"extension"`
+	Hash      string `json:"hash"`
+	Bytes     []byte `json:"-"`
+}
+
+// SchemaRegistry serves claim schemas by URL from a local schema directory or a download cache,
+// and only goes to the network for schemas it has never seen. The schema directory has a manifest
+// that maps full schema URLs to its files and their sha256, so a file only stands in for the URL it is listed for.
+// It implements the loaders.SchemaLoader interface of go-iden3-auth.
+type SchemaRegistry struct {
+	Dir      string
+	CacheDir string
+	Offline  bool
+
+	lock   sync.RWMutex
+	byURL  map[string]*SchemaEntry
+	byHash map[string]*SchemaEntry
+
+	manifestOnce sync.Once
+	manifest     map[string]SchemaManifestEntry
+}
+
+// SchemaManifestFile is the name of the manifest in the schema directory.
+const SchemaManifestFile = "manifest.json"
+
+// SchemaManifestEntry is the local file of a schema URL and the sha256 hex of its content.
+type SchemaManifestEntry struct {
+	File string `json:"file"`
+	Hash string `json:"hash"`
+}
+
+func NewSchemaRegistry(dir, cacheDir string, offline bool) *SchemaRegistry {
+	return &SchemaRegistry{
+		Dir:      dir,
+		CacheDir: cacheDir,
+		Offline:  offline,
+		byURL:    make(map[string]*SchemaEntry),
+		byHash:   make(map[string]*SchemaEntry),
+	}
+}
+
+// GetSchemaRegistry returns the registry configured in the schemas section, shared by every caller of this config.
+func (config *Config) GetSchemaRegistry() *SchemaRegistry {
+	config.schemaRegistryOnce.Do(func() {
+		config.schemaRegistry = NewSchemaRegistry(config.Schemas.Dir, config.Schemas.CacheDir, config.Schemas.Offline)
+	})
+	return config.schemaRegistry
+}
+
+// Load returns the schema bytes and the schema format (json or json-ld), detected from the content when possible.
+func (r *SchemaRegistry) Load(ctx context.Context, schema protocol.Schema) ([]byte, string, error) {
+	entry, err := r.Get(ctx, schema.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	return entry.Bytes, entry.Extension, nil
+}
+
+// Get looks the schema up in memory, then in the manifest of the schema directory, then in the cache directory,
+// and finally downloads it unless the registry is offline.
+func (r *SchemaRegistry) Get(ctx context.Context, schemaURL string) (*SchemaEntry, error) {
+	r.lock.RLock()
+	entry, ok := r.byURL[schemaURL]
+	r.lock.RUnlock()
+	if ok {
+		return entry, nil
+	}
+
+	u, err := url.Parse(schemaURL)
+	if err != nil || u.Path == "" {
+		return nil, errors.Errorf("Invalid schema URL %s.", schemaURL)
+	}
+	fileName := path.Base(u.Path)
+	extension := fileName[strings.Index(fileName, ".")+1:]
+
+	if local, ok := r.localSchema(schemaURL); ok {
+		content, err := ioutil.ReadFile(filepath.Join(r.Dir, local.File))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read local schema %s.", local.File)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != strings.ToLower(local.Hash) {
+			return nil, errors.Errorf("Local schema %s does not match the hash in the manifest.", local.File)
+		}
+		return r.add(schemaURL, extension, content), nil
+	}
+
+	cacheFile := r.cacheFile(schemaURL, extension)
+	if cacheFile != "" {
+		if content, err := ioutil.ReadFile(cacheFile); err == nil {
+			return r.add(schemaURL, extension, content), nil
+		}
+	}
+
+	if r.Offline {
+		return nil, errors.Errorf("Schema %s is not available offline.", schemaURL)
+	}
+	content, extension, err := FetchSchema(ctx, schemaURL)
+	if err != nil {
+		return nil, err
+	}
+	if cacheFile != "" {
+		if err := os.MkdirAll(r.CacheDir, 0755); err != nil {
+			log.Printf("Failed to create schema cache directory. Err %s\n", err)
+		} else if err := ioutil.WriteFile(cacheFile, content, 0644); err != nil {
+			log.Printf("Failed to cache schema %s. Err %s\n", schemaURL, err)
+		}
+	}
+	return r.add(schemaURL, extension, content), nil
+}
+
+// GetByHash returns a schema the registry already loaded, by the sha256 hex of its content.
+func (r *SchemaRegistry) GetByHash(hash string) (*SchemaEntry, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	entry, ok := r.byHash[hash]
+	return entry, ok
+}
+
+// Entries lists the schemas loaded so far.
+func (r *SchemaRegistry) Entries() []*SchemaEntry {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	entries := make([]*SchemaEntry, 0, len(r.byURL))
+	for _, entry := range r.byURL {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// GetSchemaFields lists the credential subject fields a schema maps to claim slots.
+// Every field is bound to a slot, so a credential has to provide all of them.
+func GetSchemaFields(claimType, ext string, schemaBytes []byte) ([]string, error) {
+	switch ext {
+	case jsonExt:
+		var schema struct {
+			Properties struct {
+				Index struct {
+					Default []string `json:"default"`
+				} `json:"index"`
+				Value struct {
+					Default []string `json:"default"`
+				} `json:"value"`
+			} `json:"properties"`
+		}
+		if err := json.Unmarshal(schemaBytes, &schema); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse json schema.")
+		}
+		return append(schema.Properties.Index.Default, schema.Properties.Value.Default...), nil
+	case jsonldExt:
+		var schema struct {
+			Context []map[string]json.RawMessage `json:"@context"`
+		}
+		if err := json.Unmarshal(schemaBytes, &schema); err != nil {
+			return nil, errors.Wrap(err, "Failed to parse json-ld schema.")
+		}
+		for _, context := range schema.Context {
+			raw, ok := context[claimType]
+			if !ok {
+				continue
+			}
+			var typeDefinition struct {
+				Context map[string]json.RawMessage `json:"@context"`
+			}
+			if err := json.Unmarshal(raw, &typeDefinition); err != nil {
+				return nil, errors.Wrapf(err, "Failed to parse json-ld definition of %s.", claimType)
+			}
+			var fields []string
+			for name, raw := range typeDefinition.Context {
+				var field struct {
+					Type string `json:"@type"`
+				}
+				if json.Unmarshal(raw, &field) == nil && strings.HasPrefix(field.Type, "serialization:") {
+					fields = append(fields, name)
+				}
+			}
+			sort.Strings(fields)
+			return fields, nil
+		}
+		return nil, errors.Errorf("Type %s is not defined in the schema.", claimType)
+	default:
+		return nil, errors.Errorf("Schema format %s is not supported.", ext)
+	}
+}
+
+func (r *SchemaRegistry) add(schemaURL, extension string, content []byte) *SchemaEntry {
+	sum := sha256.Sum256(content)
+	entry := &SchemaEntry{
+		URL:       schemaURL,
+		Extension: DetectSchemaFormat(extension, content),
+		Hash:      hex.EncodeToString(sum[:]),
+		Bytes:     content,
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.byURL[schemaURL] = entry
+	r.byHash[entry.Hash] = entry
+	return entry
+}
+
+// localSchema returns the manifest entry of a schema URL. The manifest is read once, a missing one means
+// that no schema is served from the schema directory.
+func (r *SchemaRegistry) localSchema(schemaURL string) (SchemaManifestEntry, bool) {
+	if r.Dir == "" {
+		return SchemaManifestEntry{}, false
+	}
+	r.manifestOnce.Do(func() {
+		content, err := ioutil.ReadFile(filepath.Join(r.Dir, SchemaManifestFile))
+		if err != nil {
+			log.Printf("No schema manifest in %s, local schemas are not used. Err %s\n", r.Dir, err)
+			return
+		}
+		err = json.Unmarshal(content, &r.manifest)
+		if err != nil {
+			log.Printf("Failed to parse schema manifest in %s. Err %s\n", r.Dir, err)
+		}
+	})
+	entry, ok := r.manifest[schemaURL]
+	return entry, ok
+}
+
+func (r *SchemaRegistry) cacheFile(schemaURL, extension string) string {
+	if r.CacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(schemaURL))
+	return filepath.Join(r.CacheDir, hex.EncodeToString(sum[:])+"."+extension)
+}
@@ -0,0 +1,190 @@
+package walletSDK
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	types2 "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+const (
+	PrivateKeySignerType = "privateKey"
+	KeystoreSignerType   = "keystore"
+	RemoteSignerType     = "remote"
+)
+
+// TxSigner signs the transactions of one Ethereum account.
+type TxSigner interface {
+	Address() common.Address
+	SignTx(tx *types2.Transaction, chainID *big.Int) (*types2.Transaction, error)
+}
+
+// NewTxSigner returns the signer configured in web3.signer. Without a signer section the raw web3.privateKey is used.
+func NewTxSigner(config *Config) (TxSigner, error) {
+	signerConfig := config.Web3.Signer
+	switch signerConfig.Type {
+	case "", PrivateKeySignerType:
+		return NewPrivateKeySigner(config.Web3.PrivateKey)
+	case KeystoreSignerType:
+		password := signerConfig.Password
+		if signerConfig.PasswordFile != "" {
+			passwordBytes, err := ioutil.ReadFile(signerConfig.PasswordFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "Failed to read keystore password file.")
+			}
+			password = strings.TrimRight(string(passwordBytes), "\r\n")
+		}
+		return NewKeystoreSigner(signerConfig.Keystore, password)
+	case RemoteSignerType:
+		return NewRemoteSigner(signerConfig.URL, signerConfig.Address)
+	default:
+		return nil, errors.Errorf("Unknown signer type %s.", signerConfig.Type)
+	}
+}
+
+type privateKeySigner struct {
+	privateKey *ecdsa.PrivateKey
+	address    common.Address
+}
+
+func NewPrivateKeySigner(hexKey string) (TxSigner, error) {
+	privateKey, err := crypto.HexToECDSA(hexKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while deriving privateKey")
+	}
+	return &privateKeySigner{privateKey: privateKey, address: crypto.PubkeyToAddress(privateKey.PublicKey)}, nil
+}
+
+// NewKeystoreSigner decrypts a go-ethereum keystore JSON file. The key is only kept in memory.
+func NewKeystoreSigner(path, password string) (TxSigner, error) {
+	keyJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read keystore file.")
+	}
+	key, err := keystore.DecryptKey(keyJSON, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decrypt keystore file.")
+	}
+	return &privateKeySigner{privateKey: key.PrivateKey, address: key.Address}, nil
+}
+
+func (signer *privateKeySigner) Address() common.Address {
+	return signer.address
+}
+
+func (signer *privateKeySigner) SignTx(tx *types2.Transaction, chainID *big.Int) (*types2.Transaction, error) {
+	return types2.SignTx(tx, types2.LatestSignerForChainID(chainID), signer.privateKey)
+}
+
+// RemoteSignRequest is sent to the remote signer as a POST to <url>/sign. Transaction is the unsigned transaction
+// in its binary encoding.
+type RemoteSignRequest struct {
+	From        common.Address `json:"from"`
+	ChainID     *hexutil.Big   `json:"chainId"`
+	Transaction hexutil.Bytes  `json:"transaction"`
+}
+
+// RemoteSignResponse is the answer of the remote signer, the signed transaction in its binary encoding.
+type RemoteSignResponse struct {
+	SignedTransaction hexutil.Bytes `json:"signedTransaction"`
+}
+
+// RemoteSigner asks a signing service on the local network to sign transactions, so that the key never enters this
+// process. The service answers GET <url>/address with {"address": "0x..."} and POST <url>/sign with a RemoteSignResponse.
+type RemoteSigner struct {
+	url     string
+	address common.Address
+	client  *http.Client
+}
+
+// NewRemoteSigner returns a signer for the account at address, or for the account of the service when address is empty.
+func NewRemoteSigner(url, address string) (TxSigner, error) {
+	if url == "" {
+		return nil, errors.New("Remote signer URL is not configured.")
+	}
+	signer := &RemoteSigner{url: strings.TrimSuffix(url, "/"), client: &http.Client{Timeout: 30 * time.Second}}
+	if address != "" {
+		if !common.IsHexAddress(address) {
+			return nil, errors.Errorf("Invalid signer address %s.", address)
+		}
+		signer.address = common.HexToAddress(address)
+		return signer, nil
+	}
+
+	resp, err := signer.client.Get(signer.url + "/address")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get address from remote signer.")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Remote signer returned %s.", resp.Status)
+	}
+	var body struct {
+		Address common.Address `json:"address"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode remote signer address.")
+	}
+	signer.address = body.Address
+	return signer, nil
+}
+
+func (signer *RemoteSigner) Address() common.Address {
+	return signer.address
+}
+
+func (signer *RemoteSigner) SignTx(tx *types2.Transaction, chainID *big.Int) (*types2.Transaction, error) {
+	txBytes, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode transaction.")
+	}
+	reqBytes, err := json.Marshal(RemoteSignRequest{
+		From:        signer.address,
+		ChainID:     (*hexutil.Big)(chainID),
+		Transaction: txBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := signer.client.Post(signer.url+"/sign", "application/json", bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to reach remote signer.")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Remote signer returned %s.", resp.Status)
+	}
+	var body RemoteSignResponse
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to decode remote signer response.")
+	}
+
+	signed := new(types2.Transaction)
+	err = signed.UnmarshalBinary(body.SignedTransaction)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid signed transaction.")
+	}
+	// The signer must sign exactly the transaction it was given, with the key of the account
+	txSigner := types2.LatestSignerForChainID(chainID)
+	if txSigner.Hash(signed) != txSigner.Hash(tx) {
+		return nil, errors.New("Remote signer signed a different transaction.")
+	}
+	sender, err := types2.Sender(txSigner, signed)
+	if err != nil || sender != signer.address {
+		return nil, errors.New("Remote signer signed with a different account.")
+	}
+	return signed, nil
+}
@@ -0,0 +1,161 @@
+package walletSDK
+
+import (
+	"math/big"
+	"time"
+
+	core "github.com/iden3/go-iden3-core"
+	"github.com/pkg/errors"
+)
+
+// AcceptedStateTransitionDelay is how long a replaced identity state is still accepted in proofs.
+var AcceptedStateTransitionDelay = time.Hour
+
+// ErrStateNotPublished is returned for a state the state contract has no transition of.
+var ErrStateNotPublished = errors.New("State was never published.")
+
+// TransitionInfo tells when a state was published and when and by which state it was replaced.
+// The replaced fields are zero for the latest state of an identity.
+type TransitionInfo struct {
+	ID                  string   `json:"id"`
+	State               *big.Int `json:"state"`
+	CreatedAtTimestamp  uint64   `json:"createdAtTimestamp"`
+	CreatedAtBlock      uint64   `json:"createdAtBlock"`
+	ReplacedAtTimestamp uint64   `json:"replacedAtTimestamp,omitempty"`
+	ReplacedAtBlock     uint64   `json:"replacedAtBlock,omitempty"`
+	ReplacedBy          *big.Int `json:"replacedBy,omitempty"`
+}
+
+func (info *TransitionInfo) IsLatest() bool {
+	return info.ReplacedBy == nil || info.ReplacedBy.Sign() == 0
+}
+
+// GetStateDataByBlock returns the state of the identity at the given block.
+func GetStateDataByBlock(config *Config, id *core.ID, blockN uint64) (*StateData, error) {
+	instance, err := getStateContract(config)
+	if err != nil {
+		return nil, err
+	}
+	blockN, timestamp, currentState, err := instance.GetStateDataByBlock(nil, id.BigInt(), blockN)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get State data by block from smart contract")
+	}
+	return &StateData{BlockNumber: blockN, BlockTimestamp: timestamp, State: currentState}, nil
+}
+
+// GetStateDataByTime returns the state of the identity at the given unix timestamp.
+func GetStateDataByTime(config *Config, id *core.ID, timestamp uint64) (*StateData, error) {
+	instance, err := getStateContract(config)
+	if err != nil {
+		return nil, err
+	}
+	blockN, timestamp, currentState, err := instance.GetStateDataByTime(nil, id.BigInt(), timestamp)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get State data by time from smart contract")
+	}
+	return &StateData{BlockNumber: blockN, BlockTimestamp: timestamp, State: currentState}, nil
+}
+
+func GetTransitionInfo(config *Config, state *big.Int) (*TransitionInfo, error) {
+	instance, err := getStateContract(config)
+	if err != nil {
+		return nil, err
+	}
+	replacedAt, createdAt, replacedAtBlock, createdAtBlock, id, replacedBy, err := instance.GetTransitionInfo(nil, state)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get transition info from smart contract")
+	}
+	if id == nil || id.Sign() == 0 {
+		return nil, ErrStateNotPublished
+	}
+	coreID, err := core.IDFromInt(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid identity in transition info.")
+	}
+	return &TransitionInfo{
+		ID:                  coreID.String(),
+		State:               state,
+		CreatedAtTimestamp:  createdAt.Uint64(),
+		CreatedAtBlock:      createdAtBlock,
+		ReplacedAtTimestamp: replacedAt.Uint64(),
+		ReplacedAtBlock:     replacedAtBlock,
+		ReplacedBy:          replacedBy,
+	}, nil
+}
+
+// GetStateHistory walks the transition chain of the identity from its first published state to its latest one.
+func GetStateHistory(config *Config, id *core.ID) ([]TransitionInfo, error) {
+	instance, err := getStateContract(config)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := instance.GetState(nil, id.BigInt())
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get State from smart contract")
+	}
+	// Identities that never published a state only have their genesis state
+	if latest.Sign() == 0 {
+		return []TransitionInfo{}, nil
+	}
+	first, err := instance.Identities(nil, id.BigInt(), big.NewInt(0))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get first State from smart contract")
+	}
+
+	var history []TransitionInfo
+	state := first.State
+	for {
+		info, err := GetTransitionInfo(config, state)
+		if err != nil {
+			return nil, err
+		}
+		if info.ID != id.String() {
+			return nil, errors.Errorf("State %s belongs to another identity.", state)
+		}
+		history = append(history, *info)
+		if info.IsLatest() || state.Cmp(latest) == 0 {
+			break
+		}
+		state = info.ReplacedBy
+	}
+	return history, nil
+}
+
+// VerifyState checks that the state is the genesis state of an identity that has not published a state yet, its latest
+// state on chain, or a state that was replaced less than acceptedDelay ago. A genesis state that was replaced is no
+// different from any other replaced state.
+func VerifyState(config *Config, id *core.ID, state *big.Int, acceptedDelay time.Duration) error {
+	isGenesis, err := checkGenesisStateID(id.BigInt(), state)
+	if err != nil {
+		return errors.Wrap(err, "Failed to check if state is genesis.")
+	}
+	currentState, err := GetCurrentState(config, id)
+	if err != nil {
+		return errors.Wrap(err, "Failed to get identity state from smart contract.")
+	}
+	if currentState.Sign() == 0 {
+		if isGenesis {
+			return nil
+		}
+		return errors.New("Identity state is not genesis and not published on chain.")
+	}
+	if currentState.Cmp(state) == 0 {
+		return nil
+	}
+
+	info, err := GetTransitionInfo(config, state)
+	if err != nil {
+		return err
+	}
+	if info.ID != id.String() {
+		return errors.New("Identity state belongs to another identity.")
+	}
+	if info.IsLatest() {
+		return errors.New("Identity state is not the latest state on chain.")
+	}
+	replacedAt := time.Unix(int64(info.ReplacedAtTimestamp), 0)
+	if time.Since(replacedAt) > acceptedDelay {
+		return errors.Errorf("Identity state was replaced at %s.", replacedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
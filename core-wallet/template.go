@@ -0,0 +1,65 @@
+package walletSDK
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CredentialTemplate describes a credential type the issuer can build from its records.
+// Updatable credentials are reissued as a new version when the record changes instead of being issued again.
+type CredentialTemplate struct {
+	Type      string                  `yaml:"type"`
+	Schema    string                  `yaml:"schema"`
+	Context   []string                `yaml:"context"`
+	Updatable bool                    `yaml:"updatable"`
+	Expiry    ExpiryPolicy            `yaml:"expiry"`
+	Fields    map[string]FieldMapping `yaml:"fields"`
+}
+
+// ExpiryPolicy sets the credential expiration either relative to issuance (After, e.g. 8760h)
+// or at a fixed date (At, YYYY-MM-DD). A credential without a policy never expires.
+type ExpiryPolicy struct {
+	After string `yaml:"after"`
+	At    string `yaml:"at"`
+}
+
+// FieldMapping maps a record field to a credential subject field.
+// Transform is one of "" (copy as is), "int", "date" (to a YYYYMMDD integer) or "enum" (looked up in Values).
+type FieldMapping struct {
+	Source    string           `yaml:"source"`
+	Transform string           `yaml:"transform"`
+	Values    map[string]int64 `yaml:"values"`
+}
+
+func (config *Config) GetCredentialTemplate(credentialType string) (*CredentialTemplate, error) {
+	for i := range config.Credentials {
+		if config.Credentials[i].Type == credentialType {
+			return &config.Credentials[i], nil
+		}
+	}
+	return nil, errors.Errorf("No credential template configured for type %s.", credentialType)
+}
+
+// ExpirationFrom returns the expiration date of a credential issued at issuedAt, or nil if it never expires.
+func (policy ExpiryPolicy) ExpirationFrom(issuedAt time.Time) (*time.Time, error) {
+	switch {
+	case policy.After != "" && policy.At != "":
+		return nil, errors.New("Only one of expiry.after and expiry.at can be set.")
+	case policy.After != "":
+		duration, err := time.ParseDuration(policy.After)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid expiry duration %s.", policy.After)
+		}
+		expiration := issuedAt.Add(duration)
+		return &expiration, nil
+	case policy.At != "":
+		expiration, err := time.Parse("2006-01-02", policy.At)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid expiry date %s.", policy.At)
+		}
+		return &expiration, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,449 @@
+package walletSDK
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	types2 "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/pkg/errors"
+)
+
+const (
+	TxPending = "pending"
+	TxMined   = "mined"
+	TxFailed  = "failed"
+
+	defaultFeeBumpPercent = 20
+	// minFeeBumpPercent is the smallest fee increase nodes accept for a replacement transaction
+	minFeeBumpPercent    = 10
+	defaultResubmitAfter = 2 * time.Minute
+	defaultMaxAttempts   = 5
+	receiptPollInterval  = 5 * time.Second
+)
+
+// SendTxFunc sends a contract call with the given options, usually an abigen transactor method.
+type SendTxFunc func(opts *bind.TransactOpts) (*types2.Transaction, error)
+
+// PendingTx is a transaction sent by the TxManager. Hashes lists every submission with the same nonce, the last one
+// carries the highest fees.
+type PendingTx struct {
+	ID          string    `json:"id"`
+	Nonce       uint64    `json:"nonce"`
+	Hashes      []string  `json:"hashes"`
+	GasFeeCap   *big.Int  `json:"gasFeeCap,omitempty"`
+	GasTipCap   *big.Int  `json:"gasTipCap,omitempty"`
+	GasPrice    *big.Int  `json:"gasPrice,omitempty"`
+	Status      string    `json:"status"`
+	MinedHash   string    `json:"minedHash,omitempty"`
+	BlockNumber uint64    `json:"blockNumber,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	SubmittedAt time.Time `json:"submittedAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Hash returns the hash of the latest submission.
+func (tx *PendingTx) Hash() string {
+	return tx.Hashes[len(tx.Hashes)-1]
+}
+
+// TxManager sends the transactions of one account. Nonces are assigned one transaction at a time, fees follow the
+// web3.fees section of the config and transactions that are not mined in time are sent again with higher fees.
+type TxManager struct {
+	config *Config
+	client *ethclient.Client
+	signer TxSigner
+
+	sendMu    sync.Mutex
+	nextNonce *uint64
+
+	mu           sync.RWMutex
+	transactions map[string]*PendingTx
+	doneHandlers []func(PendingTx)
+}
+
+func NewTxManager(config *Config) (*TxManager, error) {
+	client, err := getClient(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := NewTxSigner(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create transaction signer")
+	}
+	return &TxManager{
+		config:       config,
+		client:       client,
+		signer:       signer,
+		transactions: make(map[string]*PendingTx),
+	}, nil
+}
+
+// GetTxManager returns the transaction manager of the web3 account, shared by every caller of this config.
+func (config *Config) GetTxManager() (*TxManager, error) {
+	config.txManagerOnce.Do(func() {
+		config.txManager, config.txManagerErr = NewTxManager(config)
+	})
+	return config.txManager, config.txManagerErr
+}
+
+// Send assigns the next nonce of the account and fees to the transaction, sends it and keeps sending it again with
+// higher fees until it is mined.
+func (manager *TxManager) Send(send SendTxFunc) (*PendingTx, error) {
+	ctx := context.Background()
+	manager.sendMu.Lock()
+	defer manager.sendMu.Unlock()
+
+	var tx *types2.Transaction
+	var opts *bind.TransactOpts
+	for attempt := 0; attempt < manager.maxAttempts(); attempt++ {
+		nonce, err := manager.getNonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if opts == nil {
+			opts, err = manager.transactOpts(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		opts.Nonce = new(big.Int).SetUint64(nonce)
+
+		tx, err = send(opts)
+		if err == nil {
+			break
+		}
+		switch {
+		case isNonceTooLow(err):
+			// Another client used the account, read the nonce again
+			manager.nextNonce = nil
+		case isUnderpriced(err):
+			if !manager.bumpFees(opts) {
+				return nil, errors.Wrap(err, "Transaction is underpriced and fees are at the configured cap.")
+			}
+		default:
+			manager.nextNonce = nil
+			return nil, errors.Wrap(err, "Failed to send transaction")
+		}
+		tx = nil
+	}
+	if tx == nil {
+		return nil, errors.New("Failed to send transaction, retries exhausted.")
+	}
+	*manager.nextNonce = tx.Nonce() + 1
+
+	now := time.Now()
+	pending := &PendingTx{
+		ID:          tx.Hash().String(),
+		Nonce:       tx.Nonce(),
+		Hashes:      []string{tx.Hash().String()},
+		GasFeeCap:   opts.GasFeeCap,
+		GasTipCap:   opts.GasTipCap,
+		GasPrice:    opts.GasPrice,
+		Status:      TxPending,
+		SubmittedAt: now,
+		UpdatedAt:   now,
+	}
+	manager.mu.Lock()
+	manager.transactions[pending.ID] = pending
+	manager.mu.Unlock()
+
+	go manager.waitMined(pending.ID, opts, send)
+	result := *pending
+	return &result, nil
+}
+
+// OnDone registers a handler that is called once for every transaction that is mined or failed.
+func (manager *TxManager) OnDone(handler func(PendingTx)) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.doneHandlers = append(manager.doneHandlers, handler)
+}
+
+func (manager *TxManager) notifyDone(id string) {
+	manager.mu.RLock()
+	tx, ok := manager.transactions[id]
+	var done PendingTx
+	if ok {
+		done = *tx
+	}
+	handlers := manager.doneHandlers
+	manager.mu.RUnlock()
+	if !ok {
+		return
+	}
+	for _, handler := range handlers {
+		handler(done)
+	}
+}
+
+// GetTransaction returns the status of a transaction by the hash of its first submission.
+func (manager *TxManager) GetTransaction(id string) (PendingTx, bool) {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	tx, ok := manager.transactions[id]
+	if !ok {
+		return PendingTx{}, false
+	}
+	return *tx, true
+}
+
+// GetTransactions returns every transaction sent by this manager, optionally only those with the given status.
+func (manager *TxManager) GetTransactions(status string) []PendingTx {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	transactions := make([]PendingTx, 0, len(manager.transactions))
+	for _, tx := range manager.transactions {
+		if status == "" || tx.Status == status {
+			transactions = append(transactions, *tx)
+		}
+	}
+	return transactions
+}
+
+// waitMined polls the receipts of every submission of the transaction and replaces it with higher fees when it was
+// not mined within web3.fees.resubmitAfter.
+func (manager *TxManager) waitMined(id string, opts *bind.TransactOpts, send SendTxFunc) {
+	ctx := context.Background()
+	resubmitAt := time.Now().Add(manager.resubmitAfter())
+	attempts := 1
+	for {
+		time.Sleep(receiptPollInterval)
+
+		tx, _ := manager.GetTransaction(id)
+		// The transaction may have been confirmed by a StateUpdated event
+		if tx.Status != TxPending {
+			return
+		}
+		for _, hash := range tx.Hashes {
+			receipt, err := manager.client.TransactionReceipt(ctx, common.HexToHash(hash))
+			if err != nil {
+				continue
+			}
+			manager.update(id, func(tx *PendingTx) {
+				tx.MinedHash = hash
+				tx.BlockNumber = receipt.BlockNumber.Uint64()
+				tx.Status = TxMined
+				if receipt.Status != types2.ReceiptStatusSuccessful {
+					tx.Status = TxFailed
+					tx.Error = "Transaction reverted."
+				}
+			})
+			log.Printf("Transaction %s with nonce %d included in block %d.\n", hash, tx.Nonce, receipt.BlockNumber)
+			manager.notifyDone(id)
+			return
+		}
+
+		if time.Now().Before(resubmitAt) {
+			continue
+		}
+		if attempts >= manager.maxAttempts() {
+			manager.update(id, func(tx *PendingTx) {
+				tx.Status = TxFailed
+				tx.Error = "Transaction was not mined, retries exhausted."
+			})
+			// The nonce may still be free, the next transaction reads it from the node again
+			manager.sendMu.Lock()
+			manager.nextNonce = nil
+			manager.sendMu.Unlock()
+			log.Printf("Transaction %s with nonce %d was not mined, giving up.\n", id, tx.Nonce)
+			manager.notifyDone(id)
+			return
+		}
+		resubmitAt = time.Now().Add(manager.resubmitAfter())
+		if !manager.bumpFees(opts) {
+			log.Printf("Transaction %s with nonce %d is stuck and fees are at the configured cap.\n", id, tx.Nonce)
+			continue
+		}
+		attempts++
+		replacement, err := send(opts)
+		if err != nil {
+			// A submission was mined in the meantime, its receipt is found on the next poll
+			if !isNonceTooLow(err) {
+				log.Printf("Failed to replace transaction %s. Err %s\n", id, err)
+			}
+			continue
+		}
+		manager.update(id, func(tx *PendingTx) {
+			tx.Hashes = append(tx.Hashes, replacement.Hash().String())
+			tx.GasFeeCap = opts.GasFeeCap
+			tx.GasTipCap = opts.GasTipCap
+			tx.GasPrice = opts.GasPrice
+		})
+		log.Printf("Replaced stuck transaction %s with %s.\n", id, replacement.Hash().String())
+	}
+}
+
+// ConfirmMined marks the pending transaction that one of its submissions with the hash belongs to as mined, when the
+// block is known from elsewhere, such as a StateUpdated event. It tells whether a transaction was updated.
+func (manager *TxManager) ConfirmMined(hash string, blockNumber uint64) bool {
+	manager.mu.Lock()
+	confirmed := ""
+	for id, tx := range manager.transactions {
+		if tx.Status != TxPending {
+			continue
+		}
+		for _, submitted := range tx.Hashes {
+			if strings.EqualFold(submitted, hash) {
+				tx.MinedHash = submitted
+				tx.BlockNumber = blockNumber
+				tx.Status = TxMined
+				tx.UpdatedAt = time.Now()
+				confirmed = id
+			}
+		}
+	}
+	manager.mu.Unlock()
+	if confirmed == "" {
+		return false
+	}
+	manager.notifyDone(confirmed)
+	return true
+}
+
+func (manager *TxManager) update(id string, change func(tx *PendingTx)) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if tx, ok := manager.transactions[id]; ok {
+		change(tx)
+		tx.UpdatedAt = time.Now()
+	}
+}
+
+func (manager *TxManager) getNonce(ctx context.Context) (uint64, error) {
+	if manager.nextNonce == nil {
+		nonce, err := manager.client.PendingNonceAt(ctx, manager.signer.Address())
+		if err != nil {
+			return 0, errors.Wrap(err, "Failed to get account nonce")
+		}
+		manager.nextNonce = &nonce
+	}
+	return *manager.nextNonce, nil
+}
+
+// transactOpts returns the options of a new transaction. Chains with a base fee get EIP-1559 fee caps, other chains a
+// gas price, both limited by the configured caps.
+func (manager *TxManager) transactOpts(ctx context.Context) (*bind.TransactOpts, error) {
+	chainID := manager.config.Web3.ChainID
+	if chainID == nil {
+		return nil, errors.New("Web3 chainID is not configured.")
+	}
+	from := manager.signer.Address()
+	opts := &bind.TransactOpts{
+		From: from,
+		Signer: func(address common.Address, tx *types2.Transaction) (*types2.Transaction, error) {
+			if address != from {
+				return nil, bind.ErrNotAuthorized
+			}
+			return manager.signer.SignTx(tx, chainID)
+		},
+		Context: ctx,
+	}
+	fees := manager.config.Web3.Fees
+	opts.GasLimit = fees.GasLimit
+
+	head, err := manager.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to get latest block header")
+	}
+	if head.BaseFee == nil {
+		gasPrice, err := manager.client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to suggest gas price")
+		}
+		opts.GasPrice = minBigInt(gasPrice, fees.MaxFeePerGas)
+		return opts, nil
+	}
+
+	tip, err := manager.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to suggest gas tip cap")
+	}
+	opts.GasTipCap = minBigInt(tip, fees.MaxPriorityFeePerGas)
+	feeCap := new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), opts.GasTipCap)
+	opts.GasFeeCap = minBigInt(feeCap, fees.MaxFeePerGas)
+	if opts.GasTipCap.Cmp(opts.GasFeeCap) > 0 {
+		opts.GasTipCap = new(big.Int).Set(opts.GasFeeCap)
+	}
+	return opts, nil
+}
+
+// bumpFees raises the fees of the options by web3.fees.bumpPercent without going over the configured caps.
+// It returns false when the fees can not be raised.
+func (manager *TxManager) bumpFees(opts *bind.TransactOpts) bool {
+	fees := manager.config.Web3.Fees
+	percent := int64(fees.BumpPercent)
+	if percent == 0 {
+		percent = defaultFeeBumpPercent
+	}
+	if percent < minFeeBumpPercent {
+		percent = minFeeBumpPercent
+	}
+	bump := func(value, max *big.Int) (*big.Int, bool) {
+		bumped := new(big.Int).Mul(value, big.NewInt(100+percent))
+		bumped.Div(bumped, big.NewInt(100))
+		bumped = minBigInt(bumped, max)
+		// Replacements below the minimum bump are rejected by the nodes
+		minimum := new(big.Int).Mul(value, big.NewInt(100+minFeeBumpPercent))
+		minimum.Div(minimum, big.NewInt(100))
+		return bumped, bumped.Cmp(minimum) >= 0
+	}
+
+	if opts.GasPrice != nil {
+		gasPrice, ok := bump(opts.GasPrice, fees.MaxFeePerGas)
+		if !ok {
+			return false
+		}
+		opts.GasPrice = gasPrice
+		return true
+	}
+	feeCap, ok := bump(opts.GasFeeCap, fees.MaxFeePerGas)
+	if !ok {
+		return false
+	}
+	tip, ok := bump(opts.GasTipCap, fees.MaxPriorityFeePerGas)
+	if !ok {
+		return false
+	}
+	if tip.Cmp(feeCap) > 0 {
+		tip = new(big.Int).Set(feeCap)
+	}
+	opts.GasFeeCap, opts.GasTipCap = feeCap, tip
+	return true
+}
+
+func (manager *TxManager) resubmitAfter() time.Duration {
+	if manager.config.Web3.Fees.ResubmitAfter > 0 {
+		return manager.config.Web3.Fees.ResubmitAfter
+	}
+	return defaultResubmitAfter
+}
+
+func (manager *TxManager) maxAttempts() int {
+	if manager.config.Web3.Fees.MaxAttempts > 0 {
+		return manager.config.Web3.Fees.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// minBigInt returns value, or max when it is set and lower.
+func minBigInt(value, max *big.Int) *big.Int {
+	if max != nil && max.Sign() > 0 && value.Cmp(max) > 0 {
+		return new(big.Int).Set(max)
+	}
+	return value
+}
+
+func isNonceTooLow(err error) bool {
+	return strings.Contains(err.Error(), "nonce too low")
+}
+
+func isUnderpriced(err error) bool {
+	return strings.Contains(err.Error(), "underpriced") || strings.Contains(err.Error(), "fee cap less than block base fee")
+}
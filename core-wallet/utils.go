@@ -69,6 +69,15 @@ func checkGenesisStateID(id, state *big.Int) (bool, error) {
 	return true, nil
 }
 
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func envMapper(placeholderName string) string {
 	split := strings.Split(placeholderName, ":-")
 	defValue := ""
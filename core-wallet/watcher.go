@@ -0,0 +1,177 @@
+package walletSDK
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+	state "zkSnacks/walletSDK/contracts"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	core "github.com/iden3/go-iden3-core"
+	"github.com/pkg/errors"
+)
+
+// watchBackoff is the longest wait between attempts to subscribe again after the subscription failed.
+const watchBackoff = time.Minute
+
+// IdentityStatus is the latest state of an identity seen on chain.
+type IdentityStatus struct {
+	ID             string   `json:"id"`
+	State          *big.Int `json:"state"`
+	BlockNumber    uint64   `json:"blockNumber"`
+	BlockTimestamp uint64   `json:"blockTimestamp"`
+	TxHash         string   `json:"txHash,omitempty"`
+}
+
+// StateWatcher follows the StateUpdated events of the State contract and keeps the latest state of every identity
+// it has seen, so that services learn about state transitions without polling the contract.
+type StateWatcher struct {
+	config *Config
+
+	mu       sync.RWMutex
+	states   map[string]IdentityStatus
+	handlers []func(IdentityStatus)
+	sub      event.Subscription
+}
+
+func NewStateWatcher(config *Config) *StateWatcher {
+	return &StateWatcher{config: config, states: make(map[string]IdentityStatus)}
+}
+
+// OnStateUpdated registers a handler that is called with the new status of an identity after every state update.
+func (watcher *StateWatcher) OnStateUpdated(handler func(IdentityStatus)) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	watcher.handlers = append(watcher.handlers, handler)
+}
+
+// Track reads the current state of an identity once, so that its status is known before its next state update.
+func (watcher *StateWatcher) Track(id *core.ID) error {
+	stateData, err := GetStateData(watcher.config, id)
+	if err != nil {
+		return err
+	}
+	// Identities that never published a state have no status until their first transition
+	if stateData.State.Sign() == 0 {
+		return nil
+	}
+	watcher.update(IdentityStatus{
+		ID:             id.String(),
+		State:          stateData.State,
+		BlockNumber:    stateData.BlockNumber,
+		BlockTimestamp: stateData.BlockTimestamp,
+	}, false)
+	return nil
+}
+
+func (watcher *StateWatcher) GetStatus(id *core.ID) (IdentityStatus, bool) {
+	watcher.mu.RLock()
+	defer watcher.mu.RUnlock()
+	status, ok := watcher.states[id.String()]
+	return status, ok
+}
+
+// Start subscribes to new StateUpdated events through the websocket endpoint in web3.wsURL. Events from fromBlock
+// onwards are replayed first, a fromBlock of zero only follows new events.
+func (watcher *StateWatcher) Start(fromBlock uint64) error {
+	if watcher.config.Web3.WSURL == "" {
+		return errors.New("Web3 websocket URL is not configured.")
+	}
+	client, err := ethclient.Dial(watcher.config.Web3.WSURL)
+	if err != nil {
+		return errors.Wrap(err, "Failed to init websocket client")
+	}
+	filterer, err := state.NewStateFilterer(common.HexToAddress(watcher.config.Web3.StateTransition), client)
+	if err != nil {
+		return errors.Wrap(err, "Failed to create NewStateFilterer")
+	}
+
+	// Subscribe before replaying so that no event is lost in between
+	sink := make(chan *state.StateStateUpdated, 64)
+	sub := event.ResubscribeErr(watchBackoff, func(ctx context.Context, lastErr error) (event.Subscription, error) {
+		if lastErr != nil {
+			log.Printf("StateUpdated subscription failed, subscribing again. Err %s\n", lastErr)
+		}
+		return filterer.WatchStateUpdated(&bind.WatchOpts{Context: ctx}, sink)
+	})
+
+	if fromBlock > 0 {
+		iterator, err := filterer.FilterStateUpdated(&bind.FilterOpts{Start: fromBlock})
+		if err != nil {
+			sub.Unsubscribe()
+			return errors.Wrap(err, "Failed to filter StateUpdated events")
+		}
+		for iterator.Next() {
+			watcher.handleEvent(iterator.Event)
+		}
+		err = iterator.Error()
+		iterator.Close()
+		if err != nil {
+			sub.Unsubscribe()
+			return errors.Wrap(err, "Failed to replay StateUpdated events")
+		}
+	}
+
+	watcher.mu.Lock()
+	watcher.sub = sub
+	watcher.mu.Unlock()
+	go func() {
+		for {
+			select {
+			case stateUpdated := <-sink:
+				watcher.handleEvent(stateUpdated)
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (watcher *StateWatcher) Stop() {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if watcher.sub != nil {
+		watcher.sub.Unsubscribe()
+		watcher.sub = nil
+	}
+}
+
+func (watcher *StateWatcher) handleEvent(stateUpdated *state.StateStateUpdated) {
+	id, err := core.IDFromInt(stateUpdated.Id)
+	if err != nil {
+		log.Printf("Ignoring StateUpdated event with invalid identity %s. Err %s\n", stateUpdated.Id, err)
+		return
+	}
+	watcher.update(IdentityStatus{
+		ID:             id.String(),
+		State:          stateUpdated.State,
+		BlockNumber:    stateUpdated.BlockN,
+		BlockTimestamp: stateUpdated.Timestamp,
+		TxHash:         stateUpdated.Raw.TxHash.String(),
+	}, true)
+}
+
+// update stores the status unless a newer one is known and notifies the handlers of state updates.
+func (watcher *StateWatcher) update(status IdentityStatus, notify bool) {
+	watcher.mu.Lock()
+	if current, ok := watcher.states[status.ID]; ok && current.BlockNumber > status.BlockNumber {
+		watcher.mu.Unlock()
+		return
+	}
+	watcher.states[status.ID] = status
+	handlers := watcher.handlers
+	watcher.mu.Unlock()
+
+	if !notify {
+		return
+	}
+	for _, handler := range handlers {
+		handler(status)
+	}
+}
@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -14,10 +19,12 @@ import (
 	"github.com/iden3/go-iden3-auth/pubsignals"
 	core "github.com/iden3/go-iden3-core"
 	"github.com/iden3/go-iden3-crypto/utils"
+	"github.com/iden3/iden3comm/packers"
 	"github.com/iden3/iden3comm/protocol"
 	"github.com/pkg/errors"
 
 	"zkSnacks/walletSDK"
+	wallethttp "zkSnacks/walletSDK/http"
 )
 
 type ClaimResponseBody struct {
@@ -43,6 +50,10 @@ type ProofRequest struct {
 	ProofRequestData protocol.AuthorizationRequestMessage `json:"proofRequestData"`
 	Status           string                               `json:"status"`
 	TimeStamp        time.Time                            `json:"timeStamp"`
+	// Verifier is set when the request was signed with the key the trusted resolvers publish for its verifier
+	Verifier *walletSDK.VerifierInfo `json:"verifier,omitempty"`
+	// Unverified tells why Verifier is not set
+	Unverified string `json:"unverified,omitempty"`
 }
 
 type ProofRequestQueryBody struct {
@@ -63,6 +74,11 @@ type ProofRequestResponseBody struct {
 	Status      string                  `json:"status"`
 	TimeStamp   time.Time               `json:"timeStamp"`
 	QueryData   []ProofRequestQueryBody `json:"queryData"`
+	// Signed tells that the reason and message were signed by the verifier at VerifierHost, with the key the trusted
+	// resolvers publish for its DID. Unverified tells why they were not.
+	Signed       bool   `json:"signed"`
+	VerifierHost string `json:"verifierHost,omitempty"`
+	Unverified   string `json:"unverified,omitempty"`
 }
 
 var proofRequests []ProofRequest
@@ -71,6 +87,16 @@ func main() {
 	config, _ := walletSDK.GetConfig("./config.yaml")
 	identity, _ := walletSDK.GetIdentity("./account.json")
 
+	// Follow the state of the issuer as well, its state changes when it issues or revokes claims
+	var tracked []*core.ID
+	if issuerID, err := walletSDK.ParseIdentifier(config.Issuer.ID); err == nil {
+		tracked = append(tracked, issuerID)
+	}
+	watcher := wallethttp.StartStateWatcher(config, identity, tracked...)
+
+	// Issuers are looked up through the resolvers trusted in the config
+	resolver := walletSDK.NewResolver(config)
+
 	router := gin.Default()
 	if gin.Mode() == gin.DebugMode {
 		corsConfig := cors.DefaultConfig()
@@ -86,18 +112,31 @@ func main() {
 
 	router.POST("/api/v1/addClaim", addClaim(identity, config))
 	router.POST("/api/v1/requestProof", requestProof(identity, config))
-	router.POST("/api/v1/fetchClaimsByIssuer", fetchClaimsByIssuer(identity))
-	router.GET("/api/v1/getClaims", getClaims(identity, config))
+	router.POST("/api/v1/fetchClaimsByIssuer", fetchClaimsByIssuer(identity, config))
+	router.GET("/api/v1/getClaims", getClaims(identity, config, resolver))
+	router.GET("/api/v1/getClaimHistory/:schemaHash", getClaimHistory(identity, config, resolver))
 	router.GET("/api/v1/getAccount", getAccount(identity))
 	router.GET("/api/v1/getCurrentState", getCurrentState(config, identity))
-	router.GET("/api/v1/getAccountInfo", getAccountInfo(identity))
-	router.POST("/api/v1/addProofRequest", addProofRequest(identity))
-	router.GET("/api/v1/getProofRequests", getProofRequests())
+	router.GET("/api/v1/getAccountInfo", getAccountInfo(identity, config))
+	for _, route := range wallethttp.StateRoutes(config, identity, watcher) {
+		handler := route.Handler
+		router.Handle(route.Method, route.Path, func(c *gin.Context) { handler(c) })
+	}
+	router.POST("/api/v1/addProofRequest", addProofRequest(identity, config, resolver))
+	router.GET("/api/v1/getProofRequests", getProofRequests(config))
 	router.GET("/api/v1/acceptProofRequest", acceptProofRequest(identity, config))
 
 	router.Run("0.0.0.0:8080")
 }
 
+func getClaimHistory(identity *walletSDK.Identity, config *walletSDK.Config, resolver *walletSDK.Resolver) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		claims := identity.GetClaimHistory(c.Param("schemaHash"))
+		c.IndentedJSON(http.StatusOK, convertIden3CredClaimBodyToResponse(config, resolver, claims))
+	}
+	return gin.HandlerFunc(fn)
+}
+
 func getAccount(identity *walletSDK.Identity) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		c.IndentedJSON(http.StatusOK, identity)
@@ -105,11 +144,12 @@ func getAccount(identity *walletSDK.Identity) gin.HandlerFunc {
 	return gin.HandlerFunc(fn)
 }
 
-func getAccountInfo(identity *walletSDK.Identity) gin.HandlerFunc {
+func getAccountInfo(identity *walletSDK.Identity, config *walletSDK.Config) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 
 		responseData := map[string]interface{}{
 			"id":            identity.ID.String(),
+			"did":           identity.DID(config),
 			"identityState": identity.IDS,
 			"privateKey":    utils.HexEncode(identity.PrivateKey[:]),
 		}
@@ -162,7 +202,13 @@ func requestProof(identity *walletSDK.Identity, config *walletSDK.Config) gin.Ha
 			c.IndentedJSON(http.StatusInternalServerError, err)
 		} else {
 			if resp, err := identity.ProofRequest(request, config); err == nil {
-				c.IndentedJSON(http.StatusCreated, resp)
+				token, err := identity.PackZKPMessage(resp, config)
+				if err != nil {
+					log.Printf("Failed to pack proof response. Err %s\n", err)
+					c.IndentedJSON(http.StatusInternalServerError, "Something went wrong! Failed to pack proof")
+					return
+				}
+				c.Data(http.StatusCreated, string(packers.MediaTypeZKPMessage), token)
 			} else {
 				log.Printf("Failed to process proof request. Err %s\n", err)
 				c.IndentedJSON(http.StatusInternalServerError, "Something went wrong! Failed to generate proof")
@@ -172,32 +218,99 @@ func requestProof(identity *walletSDK.Identity, config *walletSDK.Config) gin.Ha
 	return gin.HandlerFunc(fn)
 }
 
-func sendRequestToIssuerToGetClaims(identity *walletSDK.Identity, data FetchClaimBody) ([]walletSDK.Iden3CredentialClaimBody, error) {
-	postBody, _ := json.Marshal(map[string]string{
-		"id":    identity.ID.String(),
+// requestChallenge gets a challenge from the issuer to prove control over the holder identity.
+func requestChallenge(identity *walletSDK.Identity, config *walletSDK.Config, issuerURL string) (*big.Int, error) {
+	resp, err := http.Get(issuerURL + "/api/v1/challenge?id=" + neturl.QueryEscape(identity.DID(config)))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while requesting challenge from the issuer.")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while reading challenge.")
+	}
+	var responseData struct {
+		Challenge string `json:"challenge"`
+	}
+	err = json.Unmarshal(body, &responseData)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error unmarshaling data from response.")
+	}
+	challenge, ok := new(big.Int).SetString(responseData.Challenge, 10)
+	if !ok {
+		return nil, errors.Errorf("Issuer returned an invalid challenge: %s", string(body))
+	}
+	return challenge, nil
+}
+
+func requestCredentialOffer(identity *walletSDK.Identity, config *walletSDK.Config, data FetchClaimBody) (*protocol.CredentialsOfferMessage, error) {
+	challenge, err := requestChallenge(identity, config, data.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+	postBody, _ := json.Marshal(map[string]interface{}{
+		"id":    identity.DID(config),
 		"token": data.AuthToken,
+		"auth":  identity.SignChallenge(config, challenge),
 	})
 	responseBody := bytes.NewBuffer(postBody)
 
-	resp, err := http.Post(data.IssuerURL+"/api/v1/issueClaim", "application/json", responseBody)
+	resp, err := http.Post(data.IssuerURL+"/api/v1/credentials/offer", "application/json", responseBody)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while requesting credential offer from the issuer.")
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while reading credential offer.")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Issuer refused to offer credentials: %s", string(body))
+	}
+	var offer protocol.CredentialsOfferMessage
+	err = json.Unmarshal(body, &offer)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error unmarshaling data from response.")
+	}
+	if offer.Type != protocol.CredentialOfferMessageType {
+		return nil, errors.Errorf("Unexpected message type %s.", offer.Type)
+	}
+	return &offer, nil
+}
+
+// fetchOfferedCredential sends a JWZ signed fetch request for one offered credential to the issuer agent.
+func fetchOfferedCredential(identity *walletSDK.Identity, config *walletSDK.Config, offer *protocol.CredentialsOfferMessage, credentialID string) (*walletSDK.Iden3CredentialClaimBody, error) {
+	fetchRequest := identity.NewCredentialFetchRequest(config, *offer, credentialID)
+	token, err := identity.PackZKPMessage(fetchRequest, config)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed while submitting claim to the issuer.")
+		return nil, err
+	}
+
+	resp, err := http.Post(offer.Body.URL, string(packers.MediaTypeZKPMessage), bytes.NewBuffer(token))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while sending fetch request to the issuer.")
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed while submitting claim to the issuer.")
+		return nil, errors.Wrap(err, "Failed while reading issuance response.")
 	}
-	var claims []walletSDK.Iden3CredentialClaimBody
-	err = json.Unmarshal(body, &claims)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Issuer refused to issue credential %s: %s", credentialID, string(body))
+	}
+	var issuance protocol.CredentialIssuanceMessage
+	err = json.Unmarshal(body, &issuance)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error unmarshaling data from response.")
 	}
-	return claims, nil
+	if issuance.Type != protocol.CredentialIssuanceResponseMessageType {
+		return nil, errors.Errorf("Unexpected message type %s.", issuance.Type)
+	}
+	return walletSDK.ClaimBodyFromCredential(issuance.Body.Credential)
 }
 
 // TO-DO: Remove configuation related to issuer from config.yaml file
-func fetchClaimsByIssuer(identity *walletSDK.Identity) gin.HandlerFunc {
+func fetchClaimsByIssuer(identity *walletSDK.Identity, config *walletSDK.Config) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		var body FetchClaimBody
 		c.BindJSON(&body)
@@ -213,12 +326,22 @@ func fetchClaimsByIssuer(identity *walletSDK.Identity) gin.HandlerFunc {
 			return
 		}
 
-		claims, err := sendRequestToIssuerToGetClaims(identity, body)
+		offer, err := requestCredentialOffer(identity, config, body)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
 			return
 		}
 
+		var claims []walletSDK.Iden3CredentialClaimBody
+		for _, credential := range offer.Body.Credentials {
+			claim, err := fetchOfferedCredential(identity, config, offer, credential.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+				return
+			}
+			claims = append(claims, *claim)
+		}
+
 		err = identity.AddClaimsFromIssuer(claims)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
@@ -236,9 +359,31 @@ func fetchClaimsByIssuer(identity *walletSDK.Identity) gin.HandlerFunc {
 	return gin.HandlerFunc(fn)
 }
 
-func convertIden3CredClaimBodyToResponse(claims []walletSDK.Iden3CredentialClaimBody) []ClaimResponseBody {
+func convertIden3CredClaimBodyToResponse(config *walletSDK.Config, resolver *walletSDK.Resolver, claims []walletSDK.Iden3CredentialClaimBody) []ClaimResponseBody {
 	claimsResponse := make([]ClaimResponseBody, 0)
+	// Each issuer is resolved once for all of its claims
+	statusEndpoints := make(map[string]string)
 	for _, claim := range claims {
+		issuerID := config.DIDFromID(claim.Data.IssuerID)
+		endpoint, ok := statusEndpoints[issuerID]
+		if !ok {
+			var err error
+			endpoint, err = resolver.ResolveService(issuerID, walletSDK.RevocationStatusServiceType)
+			if err != nil {
+				log.Printf("Failed to resolve the revocation status service of %s. Err %s\n", issuerID, err)
+			}
+			statusEndpoints[issuerID] = endpoint
+		}
+		revoked := false
+		if endpoint != "" {
+			status, err := getRevocationStatus(endpoint, claim.Iden3credential.RevNonce)
+			if err != nil {
+				log.Printf("Failed to get the revocation status of %s. Err %s\n", claim.Iden3credential.ID, err)
+			} else {
+				revoked = status.Revoked
+			}
+		}
+
 		claimsResponse = append(claimsResponse, ClaimResponseBody{
 			ID:               claim.Iden3credential.ID,
 			SchemaURL:        claim.Iden3credential.CredentialSchema.ID,
@@ -247,8 +392,8 @@ func convertIden3CredClaimBodyToResponse(claims []walletSDK.Iden3CredentialClaim
 			Updatable:        claim.Iden3credential.Updatable,
 			Version:          claim.Iden3credential.Version,
 			RevocationNonce:  claim.Iden3credential.RevNonce,
-			RevocationStatus: false, // TODO: get revocation status from Issuer API
-			IssuerID:         claim.Data.IssuerID.String(),
+			RevocationStatus: revoked,
+			IssuerID:         issuerID,
 			ClaimData:        claim.Iden3credential.CredentialSubject,
 			ClaimRawData:     claim.Data.Claim,
 		})
@@ -256,8 +401,26 @@ func convertIden3CredClaimBodyToResponse(claims []walletSDK.Iden3CredentialClaim
 	return claimsResponse
 }
 
+// getRevocationStatus asks the revocation status service of an issuer whether the nonce is revoked.
+func getRevocationStatus(endpoint string, nonce uint64) (*walletSDK.RevocationStatus, error) {
+	resp, err := http.Get(strings.TrimSuffix(endpoint, "/") + "/" + strconv.FormatUint(nonce, 10))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed while requesting revocation status from the issuer.")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("Issuer returned %s for the revocation status.", resp.Status)
+	}
+	var status walletSDK.RevocationStatus
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error unmarshaling revocation status.")
+	}
+	return &status, nil
+}
+
 // TODO: add error handling
-func getProofRequestToResponse() []ProofRequestResponseBody {
+func getProofRequestToResponse(config *walletSDK.Config) []ProofRequestResponseBody {
 	var proofRequestResponse []ProofRequestResponseBody
 	for _, proofRequest := range proofRequests {
 		var proofQueries []ProofRequestQueryBody
@@ -268,32 +431,43 @@ func getProofRequestToResponse() []ProofRequestResponseBody {
 			if err := json.Unmarshal(jsonStr, &query); err != nil {
 				return nil
 			}
+			schemaHash, err := walletSDK.GetHashFromClaimSchemaURL(context.Background(), config.GetSchemaRegistry(), query.Schema.URL, query.Schema.Type)
+			if err != nil {
+				log.Printf("Failed to get schema hash of %s. Err %s\n", query.Schema.URL, err)
+			}
 			proofQueries = append(proofQueries, ProofRequestQueryBody{
 				AllowedIssuers: query.AllowedIssuers,
 				SchemaURL:      query.Schema.URL,
-				SchemaHash:     walletSDK.GetHashFromClaimSchemaURL(query.Schema.URL, query.Schema.Type),
+				SchemaHash:     schemaHash,
 				CredentialType: query.Schema.Type,
 				Data:           query.Req,
 			})
 		}
+		signed, verifierHost := false, ""
+		if proofRequest.Verifier != nil {
+			signed, verifierHost = true, proofRequest.Verifier.Host
+		}
 		proofRequestResponse = append(proofRequestResponse, ProofRequestResponseBody{
-			ID:          proofRequest.ProofRequestData.ID,
-			From:        proofRequest.ProofRequestData.From,
-			To:          proofRequest.ProofRequestData.To,
-			Message:     proofRequest.ProofRequestData.Body.Message,
-			Reason:      proofRequest.ProofRequestData.Body.Reason,
-			CallbackURL: proofRequest.ProofRequestData.Body.CallbackURL,
-			TimeStamp:   proofRequest.TimeStamp,
-			Status:      proofRequest.Status,
-			QueryData:   proofQueries,
+			ID:           proofRequest.ProofRequestData.ID,
+			From:         proofRequest.ProofRequestData.From,
+			To:           proofRequest.ProofRequestData.To,
+			Message:      proofRequest.ProofRequestData.Body.Message,
+			Reason:       proofRequest.ProofRequestData.Body.Reason,
+			CallbackURL:  proofRequest.ProofRequestData.Body.CallbackURL,
+			TimeStamp:    proofRequest.TimeStamp,
+			Status:       proofRequest.Status,
+			QueryData:    proofQueries,
+			Signed:       signed,
+			VerifierHost: verifierHost,
+			Unverified:   proofRequest.Unverified,
 		})
 	}
 	return proofRequestResponse
 }
 
-func getClaims(identity *walletSDK.Identity, config *walletSDK.Config) gin.HandlerFunc {
+func getClaims(identity *walletSDK.Identity, config *walletSDK.Config, resolver *walletSDK.Resolver) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
-		receivedClaims := convertIden3CredClaimBodyToResponse(identity.GetStoredClaims())
+		receivedClaims := convertIden3CredClaimBodyToResponse(config, resolver, identity.GetStoredClaims())
 		responseData := map[string]interface{}{
 			"claims": receivedClaims,
 		}
@@ -302,7 +476,7 @@ func getClaims(identity *walletSDK.Identity, config *walletSDK.Config) gin.Handl
 	return gin.HandlerFunc(fn)
 }
 
-func addProofRequest(identity *walletSDK.Identity) gin.HandlerFunc {
+func addProofRequest(identity *walletSDK.Identity, config *walletSDK.Config, resolver *walletSDK.Resolver) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		var url struct {
 			URL string `json:"url"`
@@ -312,7 +486,21 @@ func addProofRequest(identity *walletSDK.Identity) gin.HandlerFunc {
 			fmt.Println("Error while getting url from JSON object. Err: ", err)
 			c.IndentedJSON(http.StatusInternalServerError, err)
 		} else {
-			resp, err := http.Get(url.URL + "&senderId=" + identity.ID.String())
+			requestURL, err := walletSDK.ParseRequestLink(url.URL, identity.DID(config))
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			req, err := http.NewRequest(http.MethodGet, requestURL, http.NoBody)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			// The verifier answers in the language of the browser of the holder
+			if language := c.GetHeader("Accept-Language"); language != "" {
+				req.Header.Set("Accept-Language", language)
+			}
+			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
 				fmt.Println("Failed while getting query from verifier. Err: ", err)
 				c.IndentedJSON(http.StatusInternalServerError, err)
@@ -332,10 +520,23 @@ func addProofRequest(identity *walletSDK.Identity) gin.HandlerFunc {
 				fmt.Println("Error while parsing AuthorizationRequestMessage JSON object. Err: ", err)
 				c.IndentedJSON(http.StatusInternalServerError, err)
 			} else {
+				// Unsigned requests and requests of verifiers unknown to the trusted resolvers are shown as unverified,
+				// a request with a bad signature is rejected
+				unverified := ""
+				signature := resp.Header.Get(walletSDK.RequestSignatureHeader)
+				verifier, err := walletSDK.VerifyRequestSignature(resolver, body, signature, request)
+				if errors.Is(err, walletSDK.ErrUnverifiedRequest) {
+					unverified = err.Error()
+				} else if err != nil {
+					c.IndentedJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
 				newProofRequest := ProofRequest{
 					ProofRequestData: request,
 					TimeStamp:        time.Now(),
 					Status:           "pending", // Initial status of the proof request
+					Verifier:         verifier,
+					Unverified:       unverified,
 				}
 				proofRequests = append(proofRequests, newProofRequest)
 				resp := map[string]interface{}{
@@ -348,28 +549,31 @@ func addProofRequest(identity *walletSDK.Identity) gin.HandlerFunc {
 	return gin.HandlerFunc(fn)
 }
 
-func getProofRequests() gin.HandlerFunc {
+func getProofRequests(config *walletSDK.Config) gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		responseData := map[string]interface{}{
-			"proofRequests": getProofRequestToResponse(),
+			"proofRequests": getProofRequestToResponse(config),
 		}
 		c.IndentedJSON(http.StatusCreated, responseData)
 	}
 	return gin.HandlerFunc(fn)
 }
 
-func sendProofToVerifier(verfierCallbackURL string, request protocol.AuthorizationResponseMessage) error {
-	postBody, _ := json.Marshal(request)
-	responseBody := bytes.NewBuffer(postBody)
+// sendProofToVerifier packs the response as JWZ, so the verifier can check that it was sent by this identity.
+func sendProofToVerifier(identity *walletSDK.Identity, config *walletSDK.Config, verfierCallbackURL string, response protocol.AuthorizationResponseMessage) error {
+	token, err := identity.PackZKPMessage(response, config)
+	if err != nil {
+		return errors.Wrap(err, "Failed to pack authorization response.")
+	}
 
-	resp, err := http.Post(verfierCallbackURL, "application/json", responseBody)
+	resp, err := http.Post(verfierCallbackURL, string(packers.MediaTypeZKPMessage), bytes.NewBuffer(token))
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return errors.Wrap(err, "Failed to send proof to the verifier.")
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return errors.Wrap(err, "Failed to read verifier response.")
 	}
 	var responseData map[string]interface{}
 	err = json.Unmarshal(body, &responseData)
@@ -377,7 +581,7 @@ func sendProofToVerifier(verfierCallbackURL string, request protocol.Authorizati
 		return errors.Wrap(err, "Error unmarshaling data from response.")
 	}
 	if responseData["status"] == "failed" {
-		return errors.Wrap(err, "Verifier rejected the proof.")
+		return errors.New("Verifier rejected the proof.")
 	}
 	return nil
 }
@@ -396,7 +600,7 @@ func acceptProofRequest(identity *walletSDK.Identity, config *walletSDK.Config)
 		for i, proofRequest := range proofRequests {
 			if proofRequest.ProofRequestData.ID == requestId {
 				if resp, err := identity.ProofRequest(proofRequest.ProofRequestData, config); err == nil {
-					err := sendProofToVerifier(proofRequest.ProofRequestData.Body.CallbackURL, *resp)
+					err := sendProofToVerifier(identity, config, proofRequest.ProofRequestData.Body.CallbackURL, *resp)
 					if err != nil {
 						c.IndentedJSON(http.StatusBadRequest, gin.H{
 							"error": err,
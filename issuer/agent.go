@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"zkSnacks/issuerSDK"
+	"zkSnacks/walletSDK"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gofrs/uuid"
+	"github.com/iden3/iden3comm/packers"
+	"github.com/iden3/iden3comm/protocol"
+)
+
+// offerCredentials issues the credentials of the student owning the token and offers them to the holder.
+// The holder fetches each offered credential from the agent endpoint with a JWZ signed fetch request.
+func offerCredentials(jhuIssuer *issuerSDK.Issuer) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var body IssueClaimsBody
+		c.BindJSON(&body)
+
+		templates, status, err := issueCredentials(jhuIssuer, body)
+		if err != nil {
+			respondIssuanceError(c, status, err)
+			return
+		}
+
+		holderDID, _ := jhuIssuer.Config.ToDID(body.ID)
+		var credentials []protocol.CredentialOffer
+		for _, template := range templates {
+			if claim, ok := jhuIssuer.GetLatestIssuedClaim(body.ID, template.Type); ok {
+				credentials = append(credentials, protocol.CredentialOffer{
+					ID:          claim.Iden3credential.ID,
+					Description: template.Type,
+				})
+			}
+		}
+
+		id := uuid.Must(uuid.NewV4()).String()
+		offer := protocol.CredentialsOfferMessage{
+			ID:       id,
+			Typ:      packers.MediaTypePlainMessage,
+			Type:     protocol.CredentialOfferMessageType,
+			ThreadID: id,
+			Body: protocol.CredentialsOfferMessageBody{
+				URL:         jhuIssuer.Config.Issuer.URL + "/api/v1/agent",
+				Credentials: credentials,
+			},
+			From: jhuIssuer.Identity.DID(jhuIssuer.Config),
+			To:   holderDID,
+		}
+		c.IndentedJSON(http.StatusOK, offer)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// agent handles the iden3comm messages sent to the issuer. Messages must be JWZ tokens proving the sender identity.
+func agent(jhuIssuer *issuerSDK.Issuer) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		envelope, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Failed to read message."})
+			return
+		}
+
+		message, err := walletSDK.UnpackZKPMessage(envelope, jhuIssuer.Config)
+		if err != nil {
+			log.Printf("Failed to unpack agent message. Err %s\n", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Message proof is invalid."})
+			return
+		}
+
+		switch message.Type {
+		case protocol.CredentialFetchRequestMessageType:
+			var body protocol.CredentialFetchRequestMessageBody
+			if err := json.Unmarshal(message.Body, &body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid fetch request body."})
+				return
+			}
+
+			claim, holderID, ok := jhuIssuer.GetIssuedClaimByID(body.ID)
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"message": "Credential " + body.ID + " does not exist."})
+				return
+			}
+			if !walletSDK.SameIdentity(holderID, message.From) {
+				c.JSON(http.StatusForbidden, gin.H{"message": "Credential was not issued to the sender."})
+				return
+			}
+
+			c.IndentedJSON(http.StatusOK, protocol.CredentialIssuanceMessage{
+				ID:       uuid.Must(uuid.NewV4()).String(),
+				Typ:      packers.MediaTypePlainMessage,
+				Type:     protocol.CredentialIssuanceResponseMessageType,
+				ThreadID: message.ThreadID,
+				Body: protocol.IssuanceMessageBody{
+					Credential: walletSDK.CredentialFromClaimBody(*claim),
+				},
+				From: jhuIssuer.Identity.DID(jhuIssuer.Config),
+				To:   message.From,
+			})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Message type " + string(message.Type) + " is not supported."})
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
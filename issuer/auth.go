@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+	"zkSnacks/walletSDK"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+const (
+	challengeTTL           = 5 * time.Minute
+	challengeSweepInterval = time.Minute
+)
+
+type issuedChallenge struct {
+	challenge *big.Int
+	holderID  string
+	expiresAt time.Time
+}
+
+// Challenges waiting to be signed, by challenge value. Each one can be answered once, by the holder it was issued to.
+var challenges = make(map[string]issuedChallenge)
+var challengesLock sync.Mutex
+
+// sweepChallenges drops the challenges that expired without being answered.
+func sweepChallenges() {
+	ticker := time.NewTicker(challengeSweepInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		challengesLock.Lock()
+		for value, issued := range challenges {
+			if now.After(issued.expiresAt) {
+				delete(challenges, value)
+			}
+		}
+		challengesLock.Unlock()
+	}
+}
+
+func getChallenge() gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		holderID, err := walletSDK.NormalizeIdentifier(c.Query("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "A valid holder DID is required."})
+			return
+		}
+		challenge, err := walletSDK.NewChallenge()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		expiresAt := time.Now().Add(challengeTTL)
+
+		challengesLock.Lock()
+		challenges[challenge.String()] = issuedChallenge{challenge: challenge, holderID: holderID, expiresAt: expiresAt}
+		challengesLock.Unlock()
+
+		c.IndentedJSON(http.StatusOK, gin.H{"challenge": challenge.String(), "expiresAt": expiresAt})
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// authenticateHolder checks that the holder signed the challenge it was given with a key of its identity.
+func authenticateHolder(config *walletSDK.Config, holderID string, proof *walletSDK.AuthProof) error {
+	if proof == nil {
+		return errors.New("Proof of control over the holder identity is required.")
+	}
+	if !walletSDK.SameIdentity(proof.ID, holderID) {
+		return errors.New("Auth proof is for another identity.")
+	}
+	holderID, err := walletSDK.NormalizeIdentifier(holderID)
+	if err != nil {
+		return err
+	}
+
+	challengesLock.Lock()
+	issued, ok := challenges[proof.Challenge]
+	if ok && issued.holderID == holderID {
+		delete(challenges, proof.Challenge)
+	}
+	challengesLock.Unlock()
+
+	if !ok || issued.holderID != holderID || time.Now().After(issued.expiresAt) {
+		return errors.New("No valid challenge for the holder. Request a new challenge.")
+	}
+	return walletSDK.VerifyAuthProof(*proof, issued.challenge, config)
+}
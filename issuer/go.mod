@@ -7,6 +7,7 @@ require (
 	github.com/gofrs/uuid v3.3.0+incompatible
 	github.com/iden3/go-iden3-core v0.1.0
 	github.com/iden3/go-schema-processor v0.2.0
+	github.com/iden3/iden3comm v0.1.2
 	github.com/pkg/errors v0.9.1
 	zkSnacks/issuerSDK v0.0.0-00010101000000-000000000000
 	zkSnacks/walletSDK v0.0.0-00010101000000-000000000000
@@ -41,7 +42,6 @@ require (
 	github.com/iden3/go-rapidsnark/types v0.0.2 // indirect
 	github.com/iden3/go-rapidsnark/verifier v0.0.2 // indirect
 	github.com/iden3/go-rapidsnark/witness v0.0.1 // indirect
-	github.com/iden3/iden3comm v0.1.2 // indirect
 	github.com/ipfs/go-cid v0.0.7 // indirect
 	github.com/ipfs/go-ipfs-api v0.3.0 // indirect
 	github.com/ipfs/go-ipfs-files v0.0.9 // indirect
@@ -3,15 +3,20 @@ package main
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"zkSnacks/issuerSDK"
 	"zkSnacks/walletSDK"
+	wallethttp "zkSnacks/walletSDK/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
 )
 
 type IssueClaimsBody struct {
-	Token string `json:"token"`
-	ID    string `json:"id"`
+	Token          string               `json:"token"`
+	ID             string               `json:"id"`
+	CredentialType string               `json:"credentialType"`
+	Auth           *walletSDK.AuthProof `json:"auth"`
 }
 
 func main() {
@@ -21,9 +26,27 @@ func main() {
 		log.Fatal("Failed to load students data")
 	}
 
+	resolver, err := newIssuerResolver(jhuIssuer)
+	if err != nil {
+		log.Fatal("Failed to set up the DID resolver")
+	}
+
+	go sweepChallenges()
+
+	watcher := wallethttp.StartStateWatcher(jhuIssuer.Config, jhuIssuer.Identity)
+
 	router := gin.Default()
+	router.GET("/api/v1/challenge", getChallenge())
 	router.POST("/api/v1/issueClaim", issueClaim(jhuIssuer))
+	router.POST("/api/v1/credentials/offer", offerCredentials(jhuIssuer))
+	router.POST("/api/v1/agent", agent(jhuIssuer))
 	router.GET("/api/v1/getCurrentState", getCurrentState(jhuIssuer.Config, jhuIssuer.Identity))
+	router.GET("/api/v1/claims/revocation/status/:nonce", getRevocationStatus(jhuIssuer))
+	router.GET("/api/v1/identifiers/:did", resolveDID(resolver))
+	for _, route := range wallethttp.StateRoutes(jhuIssuer.Config, jhuIssuer.Identity, watcher) {
+		handler := route.Handler
+		router.Handle(route.Method, route.Path, func(c *gin.Context) { handler(c) })
+	}
 
 	router.Run("0.0.0.0:8090")
 }
@@ -33,18 +56,127 @@ func issueClaim(jhuIssuer *issuerSDK.Issuer) gin.HandlerFunc {
 		var body IssueClaimsBody
 		c.BindJSON(&body)
 
-		ageClaimAPI, err := generateAgeClaimV2(jhuIssuer.Config, body.ID, body.Token)
+		_, status, err := issueCredentials(jhuIssuer, body)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
-		} else {
-			_, err := jhuIssuer.IssueClaim(*ageClaimAPI)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			respondIssuanceError(c, status, err)
+			return
+		}
+
+		claims := jhuIssuer.GetIssuedClaims(body.ID)
+		c.IndentedJSON(http.StatusOK, claims)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// issueCredentials issues the credentials the holder asked for and returns their templates,
+// or the HTTP status matching the failure.
+func issueCredentials(jhuIssuer *issuerSDK.Issuer, body IssueClaimsBody) ([]walletSDK.CredentialTemplate, int, error) {
+	// The token identifies the student, the auth proof shows that the holder controls the identity
+	err := authenticateHolder(jhuIssuer.Config, body.ID, body.Auth)
+	if err != nil {
+		return nil, http.StatusUnauthorized, err
+	}
+
+	// Issue every configured credential unless the holder asked for a specific type
+	templates := jhuIssuer.Config.Credentials
+	if body.CredentialType != "" {
+		template, err := jhuIssuer.Config.GetCredentialTemplate(body.CredentialType)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		templates = []walletSDK.CredentialTemplate{*template}
+	}
+
+	// Every credential is generated before any is issued, they are then issued in one state transition
+	var issuances []issuerSDK.ClaimIssuance
+	for i := range templates {
+		credential, err := generateCredential(jhuIssuer.Config, &templates[i], body.ID, body.Token)
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+
+		// Updatable credentials get a new version when the record changed, the previous one is revoked
+		issuance := issuerSDK.ClaimIssuance{Credential: *credential}
+		previous, issued := jhuIssuer.GetLatestIssuedClaim(body.ID, templates[i].Type)
+		if issued && templates[i].Updatable {
+			if !credentialChanged(&templates[i], &previous.Iden3credential, credential) {
+				continue
 			}
+			issuance.Credential.ID = previous.Iden3credential.ID
+			issuance.Credential.Version = previous.Iden3credential.Version + 1
+			issuance.Previous = previous
+		}
+		issuances = append(issuances, issuance)
+	}
+	if len(issuances) > 0 {
+		_, err = jhuIssuer.IssueClaims(issuances)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+	}
+	return templates, http.StatusOK, nil
+}
 
-			claims := jhuIssuer.GetIssuedClaims(body.ID)
-			c.IndentedJSON(http.StatusOK, claims)
+func respondIssuanceError(c *gin.Context, status int, err error) {
+	var validationErr *issuerSDK.ValidationError
+	if errors.As(err, &validationErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"message": validationErr.Error(), "errors": validationErr})
+		return
+	}
+	c.JSON(status, gin.H{"message": err.Error()})
+}
+
+func getRevocationStatus(jhuIssuer *issuerSDK.Issuer) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		nonce, err := strconv.ParseUint(c.Param("nonce"), 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Revocation nonce must be an unsigned integer."})
+			return
+		}
+		status, err := jhuIssuer.GetRevocationStatus(nonce)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, status)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// newIssuerResolver returns a resolver that publishes the endpoints of this issuer in its DID document.
+func newIssuerResolver(jhuIssuer *issuerSDK.Issuer) (*walletSDK.Resolver, error) {
+	config := jhuIssuer.Config
+	resolver := walletSDK.NewResolver(config)
+	issuerID := jhuIssuer.Identity.ID.String()
+	services := []struct {
+		fragment, serviceType, path string
+	}{
+		{"revocation-status", walletSDK.RevocationStatusServiceType, "/api/v1/claims/revocation/status"},
+		{"agent", walletSDK.AgentServiceType, "/api/v1/agent"},
+		{"challenge", walletSDK.ChallengeServiceType, "/api/v1/challenge"},
+	}
+	for _, service := range services {
+		err := resolver.AddService(issuerID, service.fragment, service.serviceType, config.Issuer.URL+service.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resolver, nil
+}
+
+func resolveDID(resolver *walletSDK.Resolver) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		did := c.Param("did")
+		if _, err := walletSDK.ParseIdentifier(did); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		document, err := resolver.Resolve(did)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
 		}
+		c.IndentedJSON(http.StatusOK, document)
 	}
 	return gin.HandlerFunc(fn)
 }
@@ -2,20 +2,10 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"log"
-	"math/big"
-	"math/rand"
-	"strconv"
-	"time"
 	"zkSnacks/walletSDK"
 
-	core "github.com/iden3/go-iden3-core"
-
-	"github.com/gofrs/uuid"
-	verifiable "github.com/iden3/go-schema-processor/verifiable"
-
 	"github.com/pkg/errors"
 )
 
@@ -68,67 +58,3 @@ func getStudentInfoByToken(token string) (*Student, error) {
 	}
 	return nil, errors.New("Failed to find student associated with token.")
 }
-
-func generateAgeClaim(config *walletSDK.Config, holderID string, token string) (*walletSDK.ClaimAPI, error) {
-	studentInfo, err := getStudentInfoByToken(token)
-	if err != nil {
-		return nil, err
-	}
-
-	birthday := new(big.Int)
-	birthday.SetString(studentInfo.BirthDate, 10)
-
-	claimAPI := walletSDK.ClaimAPI{
-		SubjectID:      holderID,
-		ClaimSchema:    "https://raw.githubusercontent.com/zkSnack/jcard-plus-schema-holder/master/claim-schemas/student-age.json-ld",
-		CredentialType: "AgeCredential",
-		IndexSlotA:     birthday,
-		ExpirationDate: time.Now().Unix() + 31536000,
-	}
-
-	return &claimAPI, nil
-}
-
-func generateAgeClaimV2(config *walletSDK.Config, holderID string, token string) (*verifiable.Iden3Credential, error) {
-	id, err := core.IDFromString(holderID)
-	if err != nil {
-		fmt.Println("Error: ", err)
-		return nil, err
-	}
-	did, err := core.NewDID(id.String(), core.WithNetwork("polygon", "mumbai"))
-	if err != nil {
-		return nil, err
-	}
-	fmt.Print("DID: ", did.String())
-	studentInfo, err := getStudentInfoByToken(token)
-	if err != nil {
-		return nil, err
-	}
-	iden3credentialAPI := verifiable.Iden3Credential{}
-	iden3credentialAPI.ID = uuid.Must(uuid.NewV4()).String()
-	iden3credentialAPI.Context = []string{
-		"https://raw.githubusercontent.com/iden3/claim-schema-vocab/main/schemas/json-ld/iden3credential.json-ld",
-		"https://raw.githubusercontent.com/zkSnack/jcard-plus-schema-holder/master/claim-schemas/student-age.json-ld",
-	}
-	iden3credentialAPI.Type = []string{
-		"Iden3Credential",
-	}
-	expiration_date := time.Unix(time.Now().Unix()+31536000, 0)
-	iden3credentialAPI.Expiration = &expiration_date
-	iden3credentialAPI.Updatable = false
-	iden3credentialAPI.Version = 1
-	iden3credentialAPI.RevNonce = rand.Uint64()
-	iden3credentialAPI.CredentialSubject = map[string]interface{}{
-		"birthDay": studentInfo.BirthDate,
-		"id":       holderID,
-		"type":     "AgeCredential",
-	}
-	iden3credentialAPI.CredentialStatus = &verifiable.CredentialStatus{
-		ID:   "http://localhost:8080/api/v1/claims/revocation/status/" + strconv.Itoa(int(iden3credentialAPI.RevNonce)),
-		Type: "SparseMerkleTreeProof", // Should be using constants but too lazy do work now
-	}
-	iden3credentialAPI.CredentialSchema.ID = "https://raw.githubusercontent.com/zkSnack/jcard-plus-schema-holder/master/claim-schemas/student-age.json-ld"
-	iden3credentialAPI.CredentialSchema.Type = "AgeCredential"
-
-	return &iden3credentialAPI, nil
-}
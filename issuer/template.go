@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+	"zkSnacks/walletSDK"
+
+	"github.com/gofrs/uuid"
+	verifiable "github.com/iden3/go-schema-processor/verifiable"
+	"github.com/pkg/errors"
+)
+
+const iden3CredentialContext = "https://raw.githubusercontent.com/iden3/claim-schema-vocab/main/schemas/json-ld/iden3credential.json-ld"
+
+var dateLayouts = []string{"20060102", "2006-01-02", "01/02/2006"}
+
+// generateCredential builds the credential described by the template for the student owning the token.
+func generateCredential(config *walletSDK.Config, template *walletSDK.CredentialTemplate, holderID string, token string) (*verifiable.Iden3Credential, error) {
+	holderDID, err := config.ToDID(holderID)
+	if err != nil {
+		return nil, err
+	}
+	studentInfo, err := getStudentInfoByToken(token)
+	if err != nil {
+		return nil, err
+	}
+	record, err := studentToRecord(studentInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	credentialSubject := map[string]interface{}{
+		"id":   holderDID,
+		"type": template.Type,
+	}
+	for field, mapping := range template.Fields {
+		value, err := applyFieldMapping(mapping, record)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to map field %s of %s.", field, template.Type)
+		}
+		credentialSubject[field] = value
+	}
+
+	expiration, err := template.Expiry.ExpirationFrom(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	iden3credentialAPI := verifiable.Iden3Credential{}
+	iden3credentialAPI.ID = uuid.Must(uuid.NewV4()).String()
+	iden3credentialAPI.Context = append([]string{iden3CredentialContext, template.Schema}, template.Context...)
+	iden3credentialAPI.Type = []string{
+		"Iden3Credential",
+	}
+	iden3credentialAPI.Expiration = expiration
+	iden3credentialAPI.Updatable = template.Updatable
+	iden3credentialAPI.Version = 1
+	iden3credentialAPI.RevNonce = rand.Uint64()
+	iden3credentialAPI.CredentialSubject = credentialSubject
+	iden3credentialAPI.CredentialStatus = &verifiable.CredentialStatus{
+		ID:   config.Issuer.URL + "/api/v1/claims/revocation/status/" + strconv.FormatUint(iden3credentialAPI.RevNonce, 10),
+		Type: verifiable.SparseMerkleTreeProof,
+	}
+	iden3credentialAPI.CredentialSchema.ID = template.Schema
+	iden3credentialAPI.CredentialSchema.Type = template.Type
+
+	return &iden3credentialAPI, nil
+}
+
+// credentialChanged tells whether the template fields of a new credential differ from the issued one.
+func credentialChanged(template *walletSDK.CredentialTemplate, issued, credential *verifiable.Iden3Credential) bool {
+	for field := range template.Fields {
+		if fmt.Sprintf("%v", issued.CredentialSubject[field]) != fmt.Sprintf("%v", credential.CredentialSubject[field]) {
+			return true
+		}
+	}
+	return false
+}
+
+// studentToRecord exposes the student fields under their json names, which is how templates refer to them.
+func studentToRecord(student *Student) (map[string]interface{}, error) {
+	content, err := json.Marshal(student)
+	if err != nil {
+		return nil, err
+	}
+	var record map[string]interface{}
+	err = json.Unmarshal(content, &record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// applyFieldMapping returns the subject value as a decimal string, which is the form the claim parsers accept.
+func applyFieldMapping(mapping walletSDK.FieldMapping, record map[string]interface{}) (string, error) {
+	raw, ok := record[mapping.Source]
+	if !ok {
+		return "", errors.Errorf("Source field %s does not exist.", mapping.Source)
+	}
+	value := strings.TrimSpace(fmt.Sprintf("%v", raw))
+
+	switch mapping.Transform {
+	case "":
+		return value, nil
+	case "int":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", errors.Errorf("Value %s is not an integer.", value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "date":
+		for _, layout := range dateLayouts {
+			if date, err := time.Parse(layout, value); err == nil {
+				return date.Format("20060102"), nil
+			}
+		}
+		return "", errors.Errorf("Value %s is not a date.", value)
+	case "enum":
+		n, ok := mapping.Values[value]
+		if !ok {
+			return "", errors.Errorf("Value %s has no enum mapping.", value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	default:
+		return "", errors.Errorf("Transform %s is not supported.", mapping.Transform)
+	}
+}
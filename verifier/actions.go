@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/iden3/go-iden3-auth/pubsignals"
+)
+
+const (
+	ActionSessionToken = "sessionToken"
+	ActionWebhook      = "webhook"
+	ActionAuditLog     = "auditLog"
+
+	// Outcomes of the verification an action runs on
+	RunOnSuccess = "success"
+	RunOnFailure = "failure"
+	RunOnAlways  = "always"
+
+	EventSessionVerified = "session.verified"
+	EventSessionFailed   = "session.failed"
+
+	defaultSessionTokenTTL = 15 * time.Minute
+	defaultWebhookTimeout  = 10 * time.Second
+)
+
+// ActionConfig configures one action run after the verification of a session. Only the fields of its type are used.
+type ActionConfig struct {
+	Type string `yaml:"type"`
+	On   string `yaml:"on"`
+	// sessionToken and webhook
+	Secret string        `yaml:"secret"`
+	TTL    time.Duration `yaml:"ttl"`
+	Cookie string        `yaml:"cookie"`
+	// webhook
+	URL string `yaml:"url"`
+	// auditLog
+	Path string `yaml:"path"`
+}
+
+// VerificationEvent describes the outcome of a session for the actions, with the claims the holder proved.
+type VerificationEvent struct {
+	Event     string             `json:"event"`
+	SessionID string             `json:"sessionId"`
+	QueryID   string             `json:"queryId,omitempty"`
+	PolicyID  string             `json:"policyId,omitempty"`
+	HolderID  string             `json:"holderId,omitempty"`
+	Scopes    []EventScope       `json:"scopes,omitempty"`
+	Result    VerificationResult `json:"result"`
+	Time      time.Time          `json:"time"`
+
+	// Token is set by the session token action for the browser that showed the QR code, it is never sent elsewhere
+	Token string `json:"-"`
+}
+
+// EventScope is the query that a scope of the request sent to the holder asked to prove.
+type EventScope struct {
+	ScopeID   uint32           `json:"scopeId"`
+	QueryID   string           `json:"queryId"`
+	CircuitID string           `json:"circuitId"`
+	Query     pubsignals.Query `json:"query"`
+}
+
+// Action is run after a session is verified or failed. Actions see the event in the order they are configured.
+type Action interface {
+	Run(ctx context.Context, event *VerificationEvent) error
+}
+
+type configuredAction struct {
+	name   string
+	on     string
+	action Action
+}
+
+// Actions runs the configured actions for the outcome of each session.
+type Actions struct {
+	actions []configuredAction
+	// cookie is the name of the cookie the browser receives the session token in, empty to not set it
+	cookie string
+}
+
+func NewActions(configs []ActionConfig, dispatcher *WebhookDispatcher) (*Actions, error) {
+	actions := new(Actions)
+	for i, actionConfig := range configs {
+		on := actionConfig.On
+		if on == "" {
+			on = RunOnAlways
+		}
+		if on != RunOnSuccess && on != RunOnFailure && on != RunOnAlways {
+			return nil, fmt.Errorf("action %d: unknown outcome %s", i, on)
+		}
+
+		var action Action
+		switch actionConfig.Type {
+		case ActionSessionToken:
+			if actionConfig.Secret == "" {
+				return nil, fmt.Errorf("action %d: sessionToken needs a secret", i)
+			}
+			if on != RunOnSuccess {
+				return nil, fmt.Errorf("action %d: sessionToken can only run on success", i)
+			}
+			action = NewSessionTokenAction([]byte(actionConfig.Secret), actionConfig.TTL)
+			actions.cookie = actionConfig.Cookie
+		case ActionWebhook:
+			if actionConfig.URL == "" || actionConfig.Secret == "" {
+				return nil, fmt.Errorf("action %d: webhook needs a url and a secret", i)
+			}
+			action = NewWebhookAction(WebhookEndpoint{URL: actionConfig.URL, Secret: actionConfig.Secret}, dispatcher)
+		case ActionAuditLog:
+			if actionConfig.Path == "" {
+				return nil, fmt.Errorf("action %d: auditLog needs a path", i)
+			}
+			action = NewAuditLogAction(actionConfig.Path)
+		default:
+			return nil, fmt.Errorf("action %d: unknown type %s", i, actionConfig.Type)
+		}
+		actions.actions = append(actions.actions, configuredAction{name: actionConfig.Type, on: on, action: action})
+	}
+	return actions, nil
+}
+
+// Cookie returns the name of the session token cookie.
+func (actions *Actions) Cookie() string {
+	return actions.cookie
+}
+
+// Run runs the actions matching the outcome of the event. A failing action is logged and does not change the
+// outcome of the verification.
+func (actions *Actions) Run(ctx context.Context, event *VerificationEvent) {
+	for _, configured := range actions.actions {
+		if configured.on == RunOnSuccess && !event.Result.Verified || configured.on == RunOnFailure && event.Result.Verified {
+			continue
+		}
+		err := configured.action.Run(ctx, event)
+		if err != nil {
+			log.Printf("Action %s failed for session %s: %s", configured.name, event.SessionID, err)
+		}
+	}
+}
+
+// newVerificationEvent builds the event of a finished session.
+func newVerificationEvent(session Session, result VerificationResult) *VerificationEvent {
+	event := &VerificationEvent{
+		Event:     EventSessionFailed,
+		SessionID: session.ID,
+		QueryID:   session.QueryID,
+		PolicyID:  session.PolicyID,
+		HolderID:  session.SenderID,
+		Result:    result,
+		Time:      time.Now().UTC(),
+	}
+	if result.Verified {
+		event.Event = EventSessionVerified
+	}
+	// The queries are the ones the request was made of, they may have changed in the store since
+	for i, query := range session.queries {
+		event.Scopes = append(event.Scopes, EventScope{
+			ScopeID:   uint32(i + 1),
+			QueryID:   query.ID,
+			CircuitID: query.CircuitID,
+			Query:     query.Query,
+		})
+	}
+	return event
+}
+
+// SessionTokenAction signs a short-lived HS256 JWT for the holder that was verified.
+type SessionTokenAction struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewSessionTokenAction(secret []byte, ttl time.Duration) *SessionTokenAction {
+	if ttl <= 0 {
+		ttl = defaultSessionTokenTTL
+	}
+	return &SessionTokenAction{secret: secret, ttl: ttl}
+}
+
+type sessionTokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	SessionID string `json:"sid"`
+	QueryID   string `json:"qid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (action *SessionTokenAction) Run(ctx context.Context, event *VerificationEvent) error {
+	if !event.Result.Verified {
+		return errors.New("session is not verified")
+	}
+	claims := sessionTokenClaims{
+		Issuer:    config.Verifier.ID,
+		Subject:   event.HolderID,
+		SessionID: event.SessionID,
+		QueryID:   event.QueryID,
+		IssuedAt:  event.Time.Unix(),
+		ExpiresAt: event.Time.Add(action.ttl).Unix(),
+	}
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, action.secret)
+	mac.Write([]byte(signingInput))
+	event.Token = signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return nil
+}
+
+// WebhookAction sends the event to one endpoint through the webhook outbox, so that it is signed and retried.
+type WebhookAction struct {
+	endpoint   WebhookEndpoint
+	dispatcher *WebhookDispatcher
+}
+
+func NewWebhookAction(endpoint WebhookEndpoint, dispatcher *WebhookDispatcher) *WebhookAction {
+	return &WebhookAction{endpoint: endpoint, dispatcher: dispatcher}
+}
+
+func (action *WebhookAction) Run(ctx context.Context, event *VerificationEvent) error {
+	action.dispatcher.Enqueue(action.endpoint, event)
+	return nil
+}
+
+// AuditLogAction appends the event as a JSON line to a file.
+type AuditLogAction struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewAuditLogAction(path string) *AuditLogAction {
+	return &AuditLogAction{path: path}
+}
+
+func (action *AuditLogAction) Run(ctx context.Context, event *VerificationEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	action.mu.Lock()
+	defer action.mu.Unlock()
+	err = os.MkdirAll(filepath.Dir(action.path), 0755)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(action.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin only lets requests with the admin bearer token of the config through. The admin endpoints are disabled
+// when no token is configured.
+func requireAdmin(token string) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are disabled"})
+			return
+		}
+		header := c.GetHeader("Authorization")
+		provided := strings.TrimPrefix(header, "Bearer ")
+		if provided == header || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="verifier admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "admin credential required"})
+			return
+		}
+		c.Next()
+	}
+	return gin.HandlerFunc(fn)
+}
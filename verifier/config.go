@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"time"
+	"zkSnacks/walletSDK"
+)
+
+// Config is the verifier configuration, loaded the same way as the walletSDK config of the other services.
+type Config struct {
+	Verifier struct {
+		ID      string `yaml:"id"`
+		Host    string `yaml:"host"`
+		Address string `yaml:"address"`
+	} `yaml:"verifier"`
+	Web3 struct {
+		URL           string `yaml:"url"`
+		StateContract string `yaml:"stateContract"`
+	} `yaml:"web3"`
+	Admin struct {
+		// Token is the bearer token of the admin endpoints, which are disabled without it
+		Token string `yaml:"token"`
+	} `yaml:"admin"`
+	Keys struct {
+		Dir string `yaml:"dir"`
+		// Signing is the Ed25519 key the requests to the holders are signed with
+		Signing string `yaml:"signing"`
+	} `yaml:"keys"`
+	Schemas struct {
+		IpfsURL string `yaml:"ipfsURL"`
+	} `yaml:"schemas"`
+	DID struct {
+		Blockchain string `yaml:"blockchain"`
+		Network    string `yaml:"network"`
+	} `yaml:"did"`
+	Sessions struct {
+		TTL time.Duration `yaml:"ttl"`
+	} `yaml:"sessions"`
+	Queries struct {
+		Store string `yaml:"store"`
+	} `yaml:"queries"`
+	Policies struct {
+		Store string `yaml:"store"`
+	} `yaml:"policies"`
+	Webhooks struct {
+		Outbox      string            `yaml:"outbox"`
+		MaxAttempts int               `yaml:"maxAttempts"`
+		Backoff     time.Duration     `yaml:"backoff"`
+		MaxBackoff  time.Duration     `yaml:"maxBackoff"`
+		Timeout     time.Duration     `yaml:"timeout"`
+		Endpoints   []WebhookEndpoint `yaml:"endpoints"`
+	} `yaml:"webhooks"`
+	// Actions are run after the verification of each session
+	Actions []ActionConfig `yaml:"actions"`
+	UI      struct {
+		StaticDir string `yaml:"staticDir"`
+		HtmlDir   string `yaml:"htmlDir"`
+	} `yaml:"ui"`
+}
+
+func GetConfig(filename string) (*Config, error) {
+	config := new(Config)
+	if err := walletSDK.LoadConfigFile(filename, config); err != nil {
+		return nil, err
+	}
+	if _, err := walletSDK.ParseIdentifier(config.Verifier.ID); err != nil {
+		return nil, errors.New("Verifier id must be a DID.")
+	}
+	return config, nil
+}
+
+// walletConfig returns the walletSDK config for the state contract and the DID network of the verifier.
+func (config *Config) walletConfig() *walletSDK.Config {
+	walletConfig := new(walletSDK.Config)
+	walletConfig.Web3.URL = config.Web3.URL
+	walletConfig.Web3.StateTransition = config.Web3.StateContract
+	walletConfig.DID.Blockchain = config.DID.Blockchain
+	walletConfig.DID.Network = config.DID.Network
+	return walletConfig
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"zkSnacks/walletSDK"
+
+	"github.com/gin-gonic/gin"
+	core "github.com/iden3/go-iden3-core"
+)
+
+// toDID returns the DID of a DID or plain identity on the network of the config.
+func toDID(identifier string) (string, error) {
+	id, err := walletSDK.ParseIdentifier(identifier)
+	if err != nil {
+		return "", err
+	}
+	did := core.DID{ID: *id}
+	if config.DID.Blockchain != "" {
+		did.Blockchain = core.Blockchain(config.DID.Blockchain)
+		did.NetworkID = core.NetworkID(config.DID.Network)
+	}
+	return did.String(), nil
+}
+
+// newVerifierResolver returns a resolver that publishes the endpoints of this verifier and the key that signs its
+// requests in its DID document, so that holders trusting this resolver check the requests without taking the host
+// of a request for granted.
+func newVerifierResolver(config *Config, signer *RequestSigner) (*walletSDK.Resolver, error) {
+	resolver := walletSDK.NewResolver(config.walletConfig())
+	err := resolver.AddService(config.Verifier.ID, "verifier-info", walletSDK.VerifierInfoServiceType, config.Verifier.Host+walletSDK.VerifierInfoPath)
+	if err != nil {
+		return nil, err
+	}
+	key := walletSDK.JWK{Kty: "OKP", Crv: "Ed25519", X: signer.PublicKey()}
+	err = resolver.AddVerificationMethod(config.Verifier.ID, "request-signing", key)
+	if err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+func resolveDID(resolver *walletSDK.Resolver) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		did := c.Param("did")
+		if _, err := walletSDK.ParseIdentifier(did); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		document, err := resolver.Resolve(did)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, document)
+	}
+	return gin.HandlerFunc(fn)
+}
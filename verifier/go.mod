@@ -4,9 +4,14 @@ go 1.18
 
 require (
 	github.com/gin-gonic/gin v1.8.1
+	github.com/google/uuid v1.2.0
 	github.com/iden3/go-circuits v0.1.1
 	github.com/iden3/go-iden3-auth v0.0.22
+	github.com/iden3/go-iden3-core v0.1.0
+	github.com/iden3/go-jwz v0.1.3
 	github.com/iden3/iden3comm v0.1.2
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	zkSnacks/walletSDK v0.0.0-00010101000000-000000000000
 )
 
 require (
@@ -17,7 +22,7 @@ require (
 	github.com/dchest/blake512 v1.0.0 // indirect
 	github.com/deckarep/golang-set v1.8.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.1 // indirect
-	github.com/ethereum/go-ethereum v1.10.24 // indirect
+	github.com/ethereum/go-ethereum v1.10.26 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-ole/go-ole v1.2.1 // indirect
 	github.com/go-playground/locales v0.14.0 // indirect
@@ -26,18 +31,15 @@ require (
 	github.com/go-stack/stack v1.8.0 // indirect
 	github.com/goccy/go-json v0.9.7 // indirect
 	github.com/gogo/protobuf v1.3.1 // indirect
-	github.com/google/uuid v1.2.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/iden3/go-iden3-core v0.1.0 // indirect
 	github.com/iden3/go-iden3-crypto v0.0.13 // indirect
-	github.com/iden3/go-jwz v0.1.3 // indirect
 	github.com/iden3/go-merkletree-sql v1.0.1 // indirect
 	github.com/iden3/go-merkletree-sql/v2 v2.0.0 // indirect
 	github.com/iden3/go-rapidsnark/prover v0.0.5 // indirect
-	github.com/iden3/go-rapidsnark/types v0.0.1 // indirect
+	github.com/iden3/go-rapidsnark/types v0.0.2 // indirect
 	github.com/iden3/go-rapidsnark/verifier v0.0.2 // indirect
 	github.com/iden3/go-rapidsnark/witness v0.0.1 // indirect
-	github.com/iden3/go-schema-processor v0.1.0 // indirect
+	github.com/iden3/go-schema-processor v0.2.0 // indirect
 	github.com/ipfs/go-cid v0.0.7 // indirect
 	github.com/ipfs/go-ipfs-api v0.3.0 // indirect
 	github.com/ipfs/go-ipfs-files v0.0.9 // indirect
@@ -64,6 +66,7 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/qri-io/jsonpointer v0.1.1 // indirect
 	github.com/qri-io/jsonschema v0.2.1 // indirect
+	github.com/rjeczalik/notify v0.9.1 // indirect
 	github.com/shirou/gopsutil v3.21.4-0.20210419000835-c7a38de76ee5+incompatible // indirect
 	github.com/spacemonkeygo/spacelog v0.0.0-20180420211403-2296661a0572 // indirect
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
@@ -81,4 +84,7 @@ require (
 	gopkg.in/natefinch/npipe.v2 v2.0.0-20160621034901-c1b8fa8bdcce // indirect
 	gopkg.in/square/go-jose.v2 v2.6.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace zkSnacks/walletSDK => ../core-wallet
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+	"zkSnacks/walletSDK"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iden3/go-circuits"
@@ -19,35 +22,88 @@ import (
 	"github.com/iden3/go-iden3-auth/loaders"
 	"github.com/iden3/go-iden3-auth/pubsignals"
 	"github.com/iden3/go-iden3-auth/state"
+	"github.com/iden3/go-jwz"
 	"github.com/iden3/iden3comm/protocol"
 )
 
-var idToQueryInfo map[string]pubsignals.Query
-var sessionIDToVerificationReqMap map[uint64]protocol.AuthorizationRequestMessage
+var queryStore *QueryStore
+var policyStore *PolicyStore
 
-const VerifierID = "1125GJqgw6YEsKFwj63GY87MMxPL9kwDKxPUiwMLNZ"
+// requestSigner signs the requests sent to the holders
+var requestSigner *RequestSigner
 
-var VerifierHost = "https://localhost:9090"
+var config *Config
+
+// schemaLoader loads the claim schemas of the queries
+var schemaLoader loaders.SchemaLoader
+var sessionStore *SessionStore
+
+// webhookDispatcher delivers the session events to the webhook endpoints
+var webhookDispatcher *WebhookDispatcher
+
+// postActions are run when a session is verified or failed
+var postActions *Actions
+
+// didResolver publishes the DID document of the verifier
+var didResolver *walletSDK.Resolver
 
 const CallbackURL = "/api/v1/callback"
 
-// Currently fixing the query directly in the code, will be changed to a dynamic query in the future
 func Init() {
-	idToQueryInfo = make(map[string]pubsignals.Query)
-	sessionIDToVerificationReqMap = make(map[uint64]protocol.AuthorizationRequestMessage)
-	idToQueryInfo["1"] = pubsignals.Query{
-		AllowedIssuers: []string{"*"},
-		Req: map[string]interface{}{
-			"birthDay": map[string]interface{}{
-				"$lt": 20100101,
-			},
-		},
-		Schema: protocol.Schema{
-			URL:  "https://raw.githubusercontent.com/zkSnack/jcard-plus-schema-holder/master/claim-schemas/student-age.json-ld",
-			Type: "AgeCredential",
-		},
+	var err error
+	config, err = GetConfig("./config.yaml")
+	if err != nil {
+		log.Fatalf("Failed to load config: %s", err)
+	}
+	schemaLoader = loaders.DefaultSchemaLoader{IpfsURL: config.Schemas.IpfsURL}
+
+	sessionStore = NewSessionStore(config.Sessions.TTL)
+	sessionStore.StartCleanup(time.Minute)
+
+	webhookDispatcher, err = LoadWebhookDispatcher(config)
+	if err != nil {
+		log.Fatalf("Failed to load webhook outbox: %s", err)
+	}
+	webhookDispatcher.Start()
+	sessionStore.OnExpire(func(session Session) {
+		webhookDispatcher.Publish(newExpiredEvent(session))
+	})
+
+	postActions, err = NewActions(config.Actions, webhookDispatcher)
+	if err != nil {
+		log.Fatalf("Failed to configure actions: %s", err)
+	}
+
+	storePath := config.Queries.Store
+	if storePath == "" {
+		storePath = defaultQueryStorePath
+	}
+	queryStore, err = LoadQueryStore(storePath, defaultQueries())
+	if err != nil {
+		log.Fatalf("Failed to load verification queries: %s", err)
+	}
+	signingPath := config.Keys.Signing
+	if signingPath == "" {
+		signingPath = defaultSigningKeyPath
+	}
+	requestSigner, err = LoadRequestSigner(signingPath)
+	if err != nil {
+		log.Fatalf("Failed to load signing key: %s", err)
+	}
+
+	policyPath := config.Policies.Store
+	if policyPath == "" {
+		policyPath = defaultPolicyStorePath
+	}
+	policyStore, err = LoadPolicyStore(policyPath)
+	if err != nil {
+		log.Fatalf("Failed to load verification policies: %s", err)
+	}
+
+	didResolver, err = newVerifierResolver(config, requestSigner)
+	if err != nil {
+		log.Fatalf("Failed to set up the DID resolver: %s", err)
 	}
-	VerifierHost = os.Getenv("DOMAIN_NAME")
 }
 
 func main() {
@@ -57,26 +113,85 @@ func main() {
 	Init()
 
 	router := gin.Default()
-	router.LoadHTMLGlob("./static/index.html")
+	router.LoadHTMLGlob(config.UI.HtmlDir)
 
 	router.GET("/", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "index.html", nil)
 	})
 
-	router.Static("/static", "./static")
+	router.Static("/static", config.UI.StaticDir)
+	router.GET("/api/v1/verifier", getVerifierInfo(requestSigner))
+	router.GET("/api/v1/identifiers/:did", resolveDID(didResolver))
 	router.GET("/api/v1/sign-in", generateQR())
+	router.GET("/api/v1/qr", getSessionQR())
 	router.GET("/api/v1/viewQuery", viewQuery())
 	router.GET("/api/v1/requestVerificationQuery", requestVerificationQuery())
 	router.POST("/api/v1/callback", authenticateCallback())
-
-	router.Run("0.0.0.0:9090")
+	router.GET("/api/v1/status", getSessionStatus(sessionStore, postActions.Cookie()))
+	router.GET("/api/v1/status/stream", streamSessionStatus(sessionStore))
+
+	// Queries and policies decide what holders have to prove, only the admin manages them and reads the webhook
+	// deliveries, which hold the claims the holders proved
+	admin := router.Group("/api/v1", requireAdmin(config.Admin.Token))
+	admin.GET("/webhooks/deliveries", listWebhookDeliveries(webhookDispatcher))
+	admin.GET("/webhooks/deliveries/:id", getWebhookDelivery(webhookDispatcher))
+	admin.POST("/queries", createRecord(queryStore, queryValidator(schemaLoader)))
+	admin.GET("/queries", listRecords(queryStore))
+	admin.GET("/queries/:id", getRecord(queryStore))
+	admin.PUT("/queries/:id", updateRecord(queryStore, queryValidator(schemaLoader)))
+	admin.DELETE("/queries/:id", disableRecord(queryStore))
+	admin.POST("/policies", createRecord(policyStore, policyValidator(queryStore)))
+	admin.GET("/policies", listRecords(policyStore))
+	admin.GET("/policies/:id", getRecord(policyStore))
+	admin.PUT("/policies/:id", updateRecord(policyStore, policyValidator(queryStore)))
+	admin.DELETE("/policies/:id", disableRecord(policyStore))
+
+	router.Run(config.Verifier.Address)
 }
 
+// generateQR starts a session for the query or the policy, the browser follows its status while the holder answers
+// the request. The QR code holds an iden3comm deep link to a signed reference of the session.
 func generateQR() gin.HandlerFunc {
 	fn := func(c *gin.Context) {
+		policyId := c.Query("policyId")
+		queryId := ""
+		if policyId == "" {
+			queryId = c.DefaultQuery("queryId", "1")
+		}
+		if _, err := resolveTarget(queryId, policyId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		nonce, err := newSessionNonce()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		session := sessionStore.Create(queryId, policyId, nonce)
+		requestURI, deepLink, err := sessionLinks(session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
 		responseData := map[string]interface{}{
-			"url": VerifierHost + "/api/v1/requestVerificationQuery?queryId=1",
+			"url":        requestURI,
+			"requestUri": requestURI,
+			"deepLink":   deepLink,
+			"qr":         fmt.Sprintf("%s/api/v1/qr?sessionId=%s", config.Verifier.Host, url.QueryEscape(session.ID)),
+			"sessionId":  session.ID,
 		}
+		// Only this browser gets the session token, the session id is in the QR code and is not secret
+		maxAge := int((2 * sessionStore.ttl).Seconds())
+		secure := strings.HasPrefix(config.Verifier.Host, "https://")
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(sessionNonceCookie+session.ID, nonce, maxAge, "/api/v1/status", "", secure, true)
+		c.Header("x-id", session.ID)
 		c.IndentedJSON(http.StatusOK, responseData)
 	}
 	return gin.HandlerFunc(fn)
@@ -92,7 +207,7 @@ func viewQuery() gin.HandlerFunc {
 			})
 			return
 		}
-		queryInfo, ok := idToQueryInfo[id]
+		queryInfo, ok := queryStore.Get(id)
 		if !ok {
 			c.JSON(http.StatusBadRequest, gin.H{
 				"error": "id not found",
@@ -100,8 +215,12 @@ func viewQuery() gin.HandlerFunc {
 			return
 		}
 		responseData := map[string]interface{}{
-			"query":      queryInfo,
-			"verifierId": VerifierID,
+			"query":        queryInfo.Query,
+			"circuitId":    queryInfo.CircuitID,
+			"reason":       queryInfo.Reason,
+			"message":      queryInfo.Message,
+			"translations": queryInfo.Translations,
+			"verifierId":   config.Verifier.ID,
 		}
 		c.IndentedJSON(http.StatusOK, responseData)
 	}
@@ -112,42 +231,97 @@ func requestVerificationQuery() gin.HandlerFunc {
 	fn := func(c *gin.Context) {
 		query := c.Request.URL.Query()
 		queryId := query.Get("queryId")
+		policyId := query.Get("policyId")
+		if query.Get("sessionId") != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "sessions of QR codes are only answered with their ref",
+			})
+			return
+		}
+		// A signed reference from a QR code stands for the session, its query and its policy
+		sessionID := ""
+		if ref := query.Get("ref"); ref != "" {
+			refSessionID, err := requestSigner.VerifyReference(ref)
+			if err != nil {
+				c.JSON(referenceErrorStatus(err), gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			session, ok := sessionStore.Get(refSessionID)
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": errSessionNotFound.Error(),
+				})
+				return
+			}
+			sessionID, queryId, policyId = session.ID, session.QueryID, session.PolicyID
+		}
 		senderId := query.Get("senderId")
-		if queryId == "" || senderId == "" {
+		if queryId == "" && policyId == "" || senderId == "" {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "queryId and senderId query parameter is required",
+				"error": "queryId or policyId and senderId query parameter is required",
 			})
 			return
 		}
-		queryInfo, ok := idToQueryInfo[queryId]
-		if !ok {
+		if policyId != "" {
+			queryId = ""
+		}
+		target, err := resolveTarget(queryId, policyId)
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "queryId not found",
+				"error": err.Error(),
 			})
 			return
 		}
-		sessionID := rand.Uint64()
-		callbackUri := fmt.Sprintf("%s%s?sessionId=", VerifierHost, CallbackURL) + strconv.FormatUint(sessionID, 10)
+		senderDID, err := toDID(senderId)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "senderId must be a DID",
+			})
+			return
+		}
+		// Requests made without scanning a QR code of the browser get their own session
+		if sessionID == "" {
+			sessionID = sessionStore.Create(queryId, policyId, "").ID
+		}
+		callbackUri := fmt.Sprintf("%s%s?sessionId=%s", config.Verifier.Host, CallbackURL, sessionID)
+
+		// The reason and message are in the language of the holder when the query has a translation for it
+		languages := acceptedLanguages(c.GetHeader("Accept-Language"))
+		if lang := query.Get("lang"); lang != "" {
+			languages = append([]string{lang}, languages...)
+		}
+		reason, message := target.Localize(languages)
 
 		var request protocol.AuthorizationRequestMessage
 		// Generate request for basic authentication
-		request = auth.CreateAuthorizationRequestWithMessage("To do adult stuff", "Age is above 18", VerifierID, callbackUri)
-		request.To = senderId
-
-		// Add request for a specific proof
-		var mtpProofRequest protocol.ZeroKnowledgeProofRequest
-		mtpProofRequest.ID = 1
-		mtpProofRequest.CircuitID = string(circuits.AtomicQuerySigCircuitID)
-		mtpProofRequest.Rules = map[string]interface{}{
-			"query": queryInfo,
-		}
+		request = auth.CreateAuthorizationRequestWithMessage(reason, message, config.Verifier.ID, callbackUri)
+		request.To = senderDID
 
-		request.Body.Scope = append(request.Body.Scope, mtpProofRequest)
+		// Add one proof request per query
+		request.Body.Scope = append(request.Body.Scope, target.Scopes()...)
 
-		// Add request to map serve it later when the callback is received
-		sessionIDToVerificationReqMap[sessionID] = request
+		// Keep the request in the session to serve it later when the callback is received
+		err = sessionStore.Scan(sessionID, senderDID, request, target)
+		if err != nil {
+			c.JSON(sessionErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
 
-		c.IndentedJSON(http.StatusOK, request)
+		// The holder checks the signature with the key of the DID document of the verifier before it shows who is
+		// asking and why
+		data, err := json.MarshalIndent(request, "", "    ")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.Header(requestSignatureHeader, requestSigner.Sign(data))
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
 	}
 	return gin.HandlerFunc(fn)
 }
@@ -162,57 +336,78 @@ func authenticateCallback() gin.HandlerFunc {
 			})
 			return
 		}
-		var response protocol.AuthorizationResponseMessage
-		if err := c.BindJSON(&response); err != nil {
-			fmt.Println("Error while parsing AuthorizationResponseMessage JSON object. Err: ", err)
+		token, err := c.GetRawData()
+		if err != nil {
+			fmt.Println("Error while reading AuthorizationResponseMessage JWZ token. Err: ", err)
 			c.IndentedJSON(http.StatusInternalServerError, err)
 			return
 		}
-		sessionIDInt, err := strconv.ParseUint(sessionID, 10, 64)
+		session, err := sessionStore.Use(sessionID, token)
 		if err != nil {
-			fmt.Println("Error while parsing sessionId. Err: ", err)
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.JSON(sessionErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
 			return
 		}
-		request, ok := sessionIDToVerificationReqMap[sessionIDInt]
-		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "sessionId not found",
+		result := Verify(token, *session.Request, session.required)
+		err = sessionStore.Complete(sessionID, result)
+		if err != nil {
+			c.JSON(sessionErrorStatus(err), gin.H{
+				"error": err.Error(),
 			})
 			return
 		}
-		verified := Verify(response, request)
+		// The outcome is recorded, the actions and the webhooks only run for sessions that can no longer expire
+		event := newVerificationEvent(session, result)
+		postActions.Run(c.Request.Context(), event)
+		err = sessionStore.Finish(sessionID, event.Token)
+		if err != nil {
+			c.JSON(sessionErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		webhookDispatcher.Publish(event)
 		status := "failed"
-		if verified {
+		if result.Verified {
 			status = "success"
 		}
 
 		resp := map[string]interface{}{
 			"status": status,
+			"result": result,
 		}
 		c.IndentedJSON(http.StatusCreated, resp)
 	}
 	return gin.HandlerFunc(fn)
 }
 
-func sendRequestWallet(postBody []byte, request protocol.AuthorizationRequestMessage) {
+func sessionErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSessionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errSessionExpired):
+		return http.StatusGone
+	case errors.Is(err, errSessionState):
+		return http.StatusBadRequest
+	default:
+		return http.StatusConflict
+	}
+}
+
+func sendRequestWallet(postBody []byte, request protocol.AuthorizationRequestMessage) (VerificationResult, error) {
 	requestBody := bytes.NewBuffer(postBody)
 
 	resp, err := http.Post("http://localhost:8080/api/v1/requestProof", "application/json", requestBody)
 	if err != nil {
-		log.Fatalf("An Error Occured %v", err)
+		return VerificationResult{}, fmt.Errorf("failed to send request to wallet: %w", err)
 	}
 	defer resp.Body.Close()
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalln(err)
+		return VerificationResult{}, fmt.Errorf("failed to read wallet response: %w", err)
 	}
-	var res protocol.AuthorizationResponseMessage
-	err = json.Unmarshal(respBody, &res)
-	if err != nil {
-		log.Fatalf("Failed to marshal response to Auth Type")
-	}
-	Verify(res, request)
+	return Verify(respBody, request, 0), nil
 }
 
 func Authenticate() {
@@ -254,35 +449,128 @@ func Authenticate() {
 	request.Body.Scope = append(request.Body.Scope, mtpProofRequest)
 
 	jsonBytes, _ := json.Marshal(request)
-	sendRequestWallet(jsonBytes, request)
+	result, err := sendRequestWallet(jsonBytes, request)
+	if err != nil {
+		log.Printf("Failed to authenticate with the wallet %s", err)
+		return
+	}
+	log.Printf("Authentication result %+v", result)
 }
 
-func Verify(response protocol.AuthorizationResponseMessage, request protocol.AuthorizationRequestMessage) bool {
+// Verify unpacks the JWZ token of the holder, which proves with the auth circuit that the response comes
+// from its sender identity, and then checks the proofs of the response against the request.
+// At least required proofs must verify, all of them when it is zero.
+// Failures are returned in the result, they never stop the verifier.
+func Verify(token []byte, request protocol.AuthorizationRequestMessage, required int) VerificationResult {
+	ctx := context.Background()
+
+	// The RPC node and the identity state contract are needed to read on-chain state,
+	// the key directory contains the verification keys of the circuits
+	var verificationKeyloader = &loaders.FSKeyLoader{Dir: config.Keys.Dir}
+	resolver := state.ETHResolver{
+		RPCUrl:   config.Web3.URL,
+		Contract: config.Web3.StateContract,
+	}
+
+	response, err := unpackAuthResponse(ctx, token, verificationKeyloader, resolver)
+	if err == nil {
+		err = checkResponseMatchesRequest(response, request)
+	}
+	var scopes []ScopeResult
+	if err == nil {
+		scopes, err = verifyScopes(ctx, *response, request, required, verificationKeyloader, resolver)
+	}
+	result := resultFromError(err)
+	result.Scopes = scopes
+	if !result.Verified {
+		log.Printf("Failed to verify %s", err)
+		return result
+	}
 
-	// Add Polygon RPC node endpoint - needed to read on-chain state
-	ethURL := "https://rpc-mumbai.maticvigil.com/"
+	fmt.Println("Successfully authenticated")
+	return result
+}
 
-	// Add identity state contract address
-	contractAddress := "0x87B36cE5393D4ea6EEf3eb7b1ca6aAd7ae295D4F"
+// checkResponseMatchesRequest rejects responses to another request and responses from another identity than the
+// one the request was sent to. The JWZ proof covers the whole message, so these fields can't be changed by a replay.
+func checkResponseMatchesRequest(response *protocol.AuthorizationResponseMessage, request protocol.AuthorizationRequestMessage) error {
+	if response.ID != request.ID || response.ThreadID != request.ThreadID {
+		return failVerification(FailureRequest, 0, fmt.Errorf("response %s of thread %s does not answer request %s", response.ID, response.ThreadID, request.ID))
+	}
+	if response.To != "" {
+		to, err := walletSDK.ParseIdentifier(response.To)
+		if err != nil {
+			return err
+		}
+		verifier, err := walletSDK.ParseIdentifier(request.From)
+		if err != nil {
+			return err
+		}
+		if to.String() != verifier.String() {
+			return failVerification(FailureRequest, 0, fmt.Errorf("response is addressed to %s", response.To))
+		}
+	}
+	if request.To != "" {
+		sender, err := walletSDK.ParseIdentifier(request.To)
+		if err != nil {
+			return err
+		}
+		if response.From != sender.String() {
+			return failVerification(FailureRequest, 0, fmt.Errorf("request was sent to %s but answered by %s", request.To, response.From))
+		}
+	}
+	return nil
+}
 
-	// Locate the directory that contains circuit's verification keys
-	keyDIR := "./keys"
+// unpackAuthResponse verifies the JWZ proof and the state of the sender, and that the sender of the
+// inner message is the identity that generated the proof. The sender may be a DID or a plain identity.
+func unpackAuthResponse(ctx context.Context, token []byte, keyLoader *loaders.FSKeyLoader, resolver state.ETHResolver) (*protocol.AuthorizationResponseMessage, error) {
+	t, err := jwz.Parse(string(token))
+	if err != nil {
+		return nil, failVerification(FailureRequest, 0, err)
+	}
+	if circuits.CircuitID(t.CircuitID) != circuits.AuthCircuitID {
+		return nil, failVerification(FailureRequest, 0, fmt.Errorf("message was packed with unsupported circuit %s", t.CircuitID))
+	}
+	authKey, err := keyLoader.Load(circuits.AuthCircuitID)
+	if err != nil {
+		return nil, err
+	}
+	isValid, err := t.Verify(authKey)
+	if err != nil {
+		return nil, failVerification(FailureProof, 0, err)
+	}
+	if !isValid {
+		return nil, failVerification(FailureProof, 0, errors.New("message proof is invalid"))
+	}
 
-	// load the verifcation key
-	var verificationKeyloader = &loaders.FSKeyLoader{Dir: keyDIR}
-	resolver := state.ETHResolver{
-		RPCUrl:   ethURL,
-		Contract: contractAddress,
+	var outputs pubsignals.Auth
+	err = t.ParsePubSignals(&outputs)
+	if err != nil {
+		return nil, failVerification(FailureProof, 0, err)
+	}
+	err = outputs.VerifyStates(ctx, resolver)
+	if err != nil {
+		return nil, failVerification(FailureState, 0, err)
 	}
 
-	// EXECUTE VERIFICATION
-	verifier := auth.NewVerifier(verificationKeyloader, loaders.DefaultSchemaLoader{IpfsURL: "ipfs.io"}, resolver)
-	err := verifier.VerifyAuthResponse(context.Background(), response, request)
+	var response protocol.AuthorizationResponseMessage
+	err = json.Unmarshal(t.GetPayload(), &response)
 	if err != nil {
-		log.Fatalf("Failed to verify %s", err)
-		return false
+		return nil, failVerification(FailureRequest, 0, err)
+	}
+	if response.Type != protocol.AuthorizationResponseMessageType {
+		return nil, failVerification(FailureRequest, 0, fmt.Errorf("unexpected message type %s", response.Type))
+	}
+	sender, err := walletSDK.ParseIdentifier(response.From)
+	if err != nil {
+		return nil, failVerification(FailureRequest, 0, err)
+	}
+	if sender.String() != outputs.UserID.String() {
+		return nil, failVerification(FailureProof, 0, fmt.Errorf("sender %s of the message did not create the proof", response.From))
 	}
 
-	fmt.Println("Successfully authenticated")
-	return true
+	// The proofs of the response are checked against the plain identity of the sender
+	response.From = sender.String()
+	return &response, nil
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iden3/iden3comm/protocol"
+)
+
+const defaultPolicyStorePath = "./data/policies.json"
+
+var errPolicyNotFound = errors.New("policy not found")
+
+// VerificationPolicy asks the holder to prove several queries in one request. MinPassed is the number of proofs
+// that must verify, zero to require all of them.
+type VerificationPolicy struct {
+	StoreRecord
+	QueryIDs     []string                 `json:"queryIds"`
+	MinPassed    int                      `json:"minPassed,omitempty"`
+	Reason       string                   `json:"reason"`
+	Message      string                   `json:"message"`
+	Translations map[string]LocalizedText `json:"translations,omitempty"`
+}
+
+type VerificationPolicyBody struct {
+	QueryIDs     []string                 `json:"queryIds"`
+	MinPassed    int                      `json:"minPassed"`
+	Reason       string                   `json:"reason"`
+	Message      string                   `json:"message"`
+	Translations map[string]LocalizedText `json:"translations"`
+	Disabled     bool                     `json:"disabled"`
+}
+
+func (policy *VerificationPolicy) apply(body VerificationPolicyBody) {
+	policy.QueryIDs = body.QueryIDs
+	policy.MinPassed = body.MinPassed
+	policy.Reason = body.Reason
+	policy.Message = body.Message
+	policy.Translations = body.Translations
+	policy.Disabled = body.Disabled
+}
+
+// PolicyStore keeps the verification policies.
+type PolicyStore = Store[VerificationPolicy, VerificationPolicyBody, *VerificationPolicy]
+
+// LoadPolicyStore reads the policies saved at path. A missing file starts an empty store.
+func LoadPolicyStore(path string) (*PolicyStore, error) {
+	return LoadStore[VerificationPolicy, VerificationPolicyBody](path, errPolicyNotFound, nil)
+}
+
+// validatePolicy checks that the queries of the policy exist and that MinPassed can be reached.
+func validatePolicy(body VerificationPolicyBody, queries *QueryStore) error {
+	if len(body.QueryIDs) == 0 {
+		return errors.New("queryIds must not be empty")
+	}
+	seen := make(map[string]bool)
+	for _, id := range body.QueryIDs {
+		if seen[id] {
+			return fmt.Errorf("query %s is used twice", id)
+		}
+		seen[id] = true
+		if _, ok := queries.Get(id); !ok {
+			return fmt.Errorf("query %s not found", id)
+		}
+	}
+	if body.MinPassed < 0 || body.MinPassed > len(body.QueryIDs) {
+		return fmt.Errorf("minPassed must be between 0 and %d", len(body.QueryIDs))
+	}
+	return nil
+}
+
+// verificationTarget is what a session asks the holder: the queries, in the order of their scope IDs, the reason
+// and message of the request and how many proofs must verify.
+type verificationTarget struct {
+	Queries      []VerificationQuery
+	Reason       string
+	Message      string
+	Translations map[string]LocalizedText
+	Required     int
+}
+
+// resolveTarget finds the queries of a session made for a policy, or for a single query when policyID is empty.
+func resolveTarget(queryID, policyID string) (*verificationTarget, error) {
+	if policyID == "" {
+		query, ok := queryStore.Get(queryID)
+		if !ok {
+			return nil, errQueryNotFound
+		}
+		if query.Disabled {
+			return nil, errors.New("query is disabled")
+		}
+		return &verificationTarget{
+			Queries:      []VerificationQuery{query},
+			Reason:       query.Reason,
+			Message:      query.Message,
+			Translations: query.Translations,
+			Required:     1,
+		}, nil
+	}
+
+	policy, ok := policyStore.Get(policyID)
+	if !ok {
+		return nil, errPolicyNotFound
+	}
+	if policy.Disabled {
+		return nil, errors.New("policy is disabled")
+	}
+	target := &verificationTarget{
+		Reason:       policy.Reason,
+		Message:      policy.Message,
+		Translations: policy.Translations,
+		Required:     policy.MinPassed,
+	}
+	for _, id := range policy.QueryIDs {
+		query, ok := queryStore.Get(id)
+		if !ok {
+			return nil, fmt.Errorf("query %s of the policy not found", id)
+		}
+		if query.Disabled {
+			return nil, fmt.Errorf("query %s of the policy is disabled", id)
+		}
+		target.Queries = append(target.Queries, query)
+	}
+	if target.Required == 0 {
+		target.Required = len(target.Queries)
+	}
+	return target, nil
+}
+
+// Localize returns the reason and message in the first of the languages that has a translation, the language tag
+// or its primary language, and the default reason and message otherwise.
+func (target *verificationTarget) Localize(languages []string) (string, string) {
+	for _, language := range languages {
+		language = strings.ToLower(language)
+		primary := strings.SplitN(language, "-", 2)[0]
+		var primaryText *LocalizedText
+		for tag, text := range target.Translations {
+			tag = strings.ToLower(tag)
+			if tag == language {
+				return text.Reason, text.Message
+			}
+			if tag == primary {
+				text := text
+				primaryText = &text
+			}
+		}
+		if primaryText != nil {
+			return primaryText.Reason, primaryText.Message
+		}
+	}
+	return target.Reason, target.Message
+}
+
+// acceptedLanguages returns the language tags of an Accept-Language header, most preferred first.
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var languages []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		if q > 0 {
+			languages = append(languages, weighted{tag: tag, q: q})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].q > languages[j].q })
+	tags := make([]string, len(languages))
+	for i, language := range languages {
+		tags[i] = language.tag
+	}
+	return tags
+}
+
+// Scopes returns one proof request per query, with the scope IDs 1, 2, ... that the holder uses as challenges.
+func (target *verificationTarget) Scopes() []protocol.ZeroKnowledgeProofRequest {
+	scopes := make([]protocol.ZeroKnowledgeProofRequest, len(target.Queries))
+	for i, query := range target.Queries {
+		scopes[i] = protocol.ZeroKnowledgeProofRequest{
+			ID:        uint32(i + 1),
+			CircuitID: query.CircuitID,
+			Rules: map[string]interface{}{
+				"query": query.Query,
+			},
+		}
+	}
+	return scopes
+}
+
+// policyValidator checks the policies of the request bodies against the queries of the store.
+func policyValidator(queries *QueryStore) bodyValidator[VerificationPolicyBody] {
+	return func(ctx context.Context, body VerificationPolicyBody) error {
+		return validatePolicy(body, queries)
+	}
+}
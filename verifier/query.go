@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"zkSnacks/walletSDK"
+
+	"github.com/iden3/go-circuits"
+	"github.com/iden3/go-iden3-auth/loaders"
+	"github.com/iden3/go-iden3-auth/pubsignals"
+	"github.com/iden3/iden3comm/protocol"
+)
+
+const defaultQueryStorePath = "./data/queries.json"
+
+// LocalizedText is the reason and message of a request in one language.
+type LocalizedText struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// VerificationQuery is a query that holders are asked to prove, together with the circuit that proves it and the
+// reason and message shown to the holder. Translations are keyed by language tag, like "de" or "pt-BR".
+type VerificationQuery struct {
+	StoreRecord
+	CircuitID    string                   `json:"circuitId"`
+	Query        pubsignals.Query         `json:"query"`
+	Reason       string                   `json:"reason"`
+	Message      string                   `json:"message"`
+	Translations map[string]LocalizedText `json:"translations,omitempty"`
+}
+
+type VerificationQueryBody struct {
+	CircuitID    string                   `json:"circuitId"`
+	Query        pubsignals.Query         `json:"query"`
+	Reason       string                   `json:"reason"`
+	Message      string                   `json:"message"`
+	Translations map[string]LocalizedText `json:"translations"`
+	Disabled     bool                     `json:"disabled"`
+}
+
+func (query *VerificationQuery) apply(body VerificationQueryBody) {
+	query.CircuitID = body.CircuitID
+	query.Query = body.Query
+	query.Reason = body.Reason
+	query.Message = body.Message
+	query.Translations = body.Translations
+	query.Disabled = body.Disabled
+}
+
+// QueryStore keeps the verification queries.
+type QueryStore = Store[VerificationQuery, VerificationQueryBody, *VerificationQuery]
+
+// LoadQueryStore reads the queries saved at path. A missing file starts the store with the default queries.
+func LoadQueryStore(path string, defaults []VerificationQuery) (*QueryStore, error) {
+	return LoadStore[VerificationQuery, VerificationQueryBody](path, errQueryNotFound, defaults)
+}
+
+var errQueryNotFound = errors.New("query not found")
+
+// validateQuery checks that the query can be proved by the circuit: the issuers are DIDs or "*", and the query
+// passes the same validation the holder runs before proving it. Holders only prove with the Sig circuit, queries for
+// the MTP circuit could never be answered.
+func validateQuery(ctx context.Context, circuitID string, query pubsignals.Query, loader loaders.SchemaLoader) error {
+	if circuits.CircuitID(circuitID) != circuits.AtomicQuerySigCircuitID {
+		return fmt.Errorf("circuit %s is not supported, use %s", circuitID, circuits.AtomicQuerySigCircuitID)
+	}
+
+	if len(query.AllowedIssuers) == 0 {
+		return errors.New("allowedIssuers must not be empty, use \"*\" to allow any issuer")
+	}
+	for _, issuer := range query.AllowedIssuers {
+		if issuer == "*" {
+			continue
+		}
+		if _, err := walletSDK.ParseIdentifier(issuer); err != nil {
+			return fmt.Errorf("invalid allowed issuer %s: %w", issuer, err)
+		}
+	}
+
+	_, err := walletSDK.ValidateAndGetCircuitsQuery(query, ctx, loader)
+	return err
+}
+
+// queryValidator checks the queries of the request bodies against the schemas of the loader.
+func queryValidator(loader loaders.SchemaLoader) bodyValidator[VerificationQueryBody] {
+	return func(ctx context.Context, body VerificationQueryBody) error {
+		return validateQuery(ctx, body.CircuitID, body.Query, loader)
+	}
+}
+
+// defaultQueries is the query the verifier started with before queries could be managed.
+func defaultQueries() []VerificationQuery {
+	now := time.Now().UTC()
+	return []VerificationQuery{{
+		StoreRecord: StoreRecord{
+			ID:        "1",
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		CircuitID: string(circuits.AtomicQuerySigCircuitID),
+		Query: pubsignals.Query{
+			AllowedIssuers: []string{"*"},
+			Req: map[string]interface{}{
+				"birthDay": map[string]interface{}{
+					"$lt": 20100101,
+				},
+			},
+			Schema: protocol.Schema{
+				URL:  "https://raw.githubusercontent.com/zkSnack/jcard-plus-schema-holder/master/claim-schemas/student-age.json-ld",
+				Type: "AgeCredential",
+			},
+		},
+		Reason:  "To do adult stuff",
+		Message: "Age is above 18",
+	}}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/iden3/iden3comm/protocol"
+)
+
+const (
+	SessionCreated = "created"
+	SessionScanned = "scanned"
+	// SessionVerifying is the status of a session from its callback until it is finished, it does not expire
+	SessionVerifying = "verifying"
+	SessionVerified  = "verified"
+	SessionFailed    = "failed"
+	SessionExpired   = "expired"
+
+	defaultSessionTTL = 10 * time.Minute
+	// sessionNonceCookie prefixes the name of the cookie that binds a session to the browser that signed in with it
+	sessionNonceCookie = "verifier_sign_in_"
+	// statusStreamTimeout ends status streams of browsers that stay open longer than a session can live
+	statusStreamTimeout = 30 * time.Minute
+)
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errSessionExpired  = errors.New("session expired")
+	errSessionState    = errors.New("session is not in the expected state")
+	errSessionUsed     = errors.New("session was already used")
+	errTokenReplayed   = errors.New("response was already submitted")
+)
+
+// Session follows one verification from the QR code shown in the browser to the result of the holder's proof.
+type Session struct {
+	ID       string              `json:"sessionId"`
+	QueryID  string              `json:"queryId,omitempty"`
+	PolicyID string              `json:"policyId,omitempty"`
+	Status   string              `json:"status"`
+	SenderID string              `json:"senderId,omitempty"`
+	Result   *VerificationResult `json:"result,omitempty"`
+	// Token is the session token signed when the session is verified, it is only released to the browser that
+	// signed in with the session
+	Token     string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	Request *protocol.AuthorizationRequestMessage `json:"-"`
+	// queries are the queries of the request, in the order of their scope IDs
+	queries []VerificationQuery
+	// required is the number of proofs of the request that must verify
+	required int
+	// used is set by the first callback, so that each request is answered only once
+	used bool
+	// nonce is the value of the sign-in cookie of the browser that created the session, empty for sessions started
+	// by a holder
+	nonce string
+}
+
+func (session *Session) isFinal() bool {
+	return session.Status == SessionVerified || session.Status == SessionFailed || session.Status == SessionExpired
+}
+
+// signedInWith tells if nonce is the sign-in cookie of the browser that created the session.
+func (session *Session) signedInWith(nonce string) bool {
+	return session.nonce != "" && subtle.ConstantTimeCompare([]byte(session.nonce), []byte(nonce)) == 1
+}
+
+// newSessionNonce returns a random value for the sign-in cookie of a browser.
+func newSessionNonce() (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// SessionStore keeps the sessions in memory. Sessions expire ttl after they were created unless they are finished,
+// and are removed ttl after their last update so that the browser can still read the outcome.
+type SessionStore struct {
+	ttl time.Duration
+
+	mu          sync.Mutex
+	sessions    map[string]*Session
+	subscribers map[string][]chan Session
+	// onExpire is called in the background for every session that expires
+	onExpire func(session Session)
+	// usedTokens holds the hashes of the submitted responses until they are older than the TTL
+	usedTokens map[[sha256.Size]byte]time.Time
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return &SessionStore{
+		ttl:         ttl,
+		sessions:    make(map[string]*Session),
+		subscribers: make(map[string][]chan Session),
+		usedTokens:  make(map[[sha256.Size]byte]time.Time),
+	}
+}
+
+// Create starts a session for a query, or for a policy when policyID is not empty. The session token is only released
+// with nonce, the session of a holder that started without a browser has none.
+func (store *SessionStore) Create(queryID, policyID, nonce string) Session {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now().UTC()
+	session := &Session{
+		ID:        uuid.New().String(),
+		QueryID:   queryID,
+		PolicyID:  policyID,
+		Status:    SessionCreated,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(store.ttl),
+		nonce:     nonce,
+	}
+	store.sessions[session.ID] = session
+	return *session
+}
+
+func (store *SessionStore) Get(id string) (Session, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	session, ok := store.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	store.expire(session, time.Now().UTC())
+	return *session, true
+}
+
+// OnExpire sets the function called for every session that expires before it is finished.
+func (store *SessionStore) OnExpire(onExpire func(session Session)) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.onExpire = onExpire
+}
+
+// Scan stores the request sent to the holder who scanned the QR code of the session, the queries it was made of and
+// how many of its proofs must verify.
+func (store *SessionStore) Scan(id, senderID string, request protocol.AuthorizationRequestMessage, target *verificationTarget) error {
+	return store.update(id, func(session *Session) error {
+		if session.Status != SessionCreated {
+			return errSessionState
+		}
+		session.Status = SessionScanned
+		session.SenderID = senderID
+		session.Request = &request
+		session.queries = target.Queries
+		session.required = target.Required
+		return nil
+	})
+}
+
+// Use marks the session of a callback as used and returns it. The session is verifying until Finish, it does not
+// expire in between. A session can be used once, and a response token can be submitted once to any session.
+func (store *SessionStore) Use(id string, token []byte) (Session, error) {
+	tokenHash := sha256.Sum256(token)
+	var used Session
+	err := store.update(id, func(session *Session) error {
+		if session.used {
+			return errSessionUsed
+		}
+		if session.Status != SessionScanned || session.Request == nil {
+			return errSessionState
+		}
+		if _, ok := store.usedTokens[tokenHash]; ok {
+			return errTokenReplayed
+		}
+		session.used = true
+		session.Status = SessionVerifying
+		store.usedTokens[tokenHash] = time.Now().UTC()
+		used = *session
+		return nil
+	})
+	return used, err
+}
+
+// Complete records the outcome of the verification of the holder's response. The session stays verifying, so that
+// the actions of the outcome can run before the browser sees it.
+func (store *SessionStore) Complete(id string, result VerificationResult) error {
+	return store.update(id, func(session *Session) error {
+		if session.Status != SessionVerifying || session.Result != nil {
+			return errSessionState
+		}
+		session.Result = &result
+		return nil
+	})
+}
+
+// Finish ends a completed session with its outcome and the session token of the browser.
+func (store *SessionStore) Finish(id string, token string) error {
+	return store.update(id, func(session *Session) error {
+		if session.Status != SessionVerifying || session.Result == nil {
+			return errSessionState
+		}
+		session.Status = SessionFailed
+		if session.Result.Verified {
+			session.Status = SessionVerified
+		}
+		session.Token = token
+		return nil
+	})
+}
+
+// Subscribe returns a channel that receives the session on every status change. The channel is closed once the
+// session is finished or removed, or when cancel is called.
+func (store *SessionStore) Subscribe(id string) (<-chan Session, func(), error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	session, ok := store.sessions[id]
+	if !ok {
+		return nil, nil, errSessionNotFound
+	}
+	store.expire(session, time.Now().UTC())
+	updates := make(chan Session, 4)
+	updates <- *session
+	if session.isFinal() {
+		close(updates)
+		return updates, func() {}, nil
+	}
+	store.subscribers[id] = append(store.subscribers[id], updates)
+	cancel := func() {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		subscribers := store.subscribers[id]
+		for i, subscriber := range subscribers {
+			if subscriber == updates {
+				store.subscribers[id] = append(subscribers[:i], subscribers[i+1:]...)
+				close(updates)
+				break
+			}
+		}
+	}
+	return updates, cancel, nil
+}
+
+// Cleanup expires the sessions that outlived their TTL and removes the finished sessions that were not updated
+// for a TTL.
+func (store *SessionStore) Cleanup() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now().UTC()
+	for id, session := range store.sessions {
+		store.expire(session, now)
+		if session.isFinal() && now.Sub(session.UpdatedAt) > store.ttl {
+			delete(store.sessions, id)
+			store.notify(session)
+		}
+	}
+	// Responses can only answer sessions that are still alive, older hashes are not needed
+	for tokenHash, usedAt := range store.usedTokens {
+		if now.Sub(usedAt) > store.ttl {
+			delete(store.usedTokens, tokenHash)
+		}
+	}
+}
+
+// StartCleanup runs Cleanup every interval in the background.
+func (store *SessionStore) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			store.Cleanup()
+		}
+	}()
+}
+
+func (store *SessionStore) update(id string, change func(session *Session) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	session, ok := store.sessions[id]
+	if !ok {
+		return errSessionNotFound
+	}
+	now := time.Now().UTC()
+	store.expire(session, now)
+	if session.Status == SessionExpired {
+		return errSessionExpired
+	}
+	status := session.Status
+	err := change(session)
+	if err != nil {
+		return err
+	}
+	session.UpdatedAt = now
+	if session.Status != status {
+		store.notify(session)
+	}
+	return nil
+}
+
+// expire marks a session that is not finished or verifying past its expiry time. The caller holds the lock.
+func (store *SessionStore) expire(session *Session, now time.Time) {
+	if session.isFinal() || session.Status == SessionVerifying || now.Before(session.ExpiresAt) {
+		return
+	}
+	session.Status = SessionExpired
+	session.UpdatedAt = now
+	store.notify(session)
+	if store.onExpire != nil {
+		go store.onExpire(*session)
+	}
+}
+
+// notify sends the session to its subscribers and closes their channels once it is finished. The caller holds the lock.
+func (store *SessionStore) notify(session *Session) {
+	_, exists := store.sessions[session.ID]
+	final := session.isFinal() || !exists
+	for _, subscriber := range store.subscribers[session.ID] {
+		select {
+		case subscriber <- *session:
+		default:
+		}
+		if final {
+			close(subscriber)
+		}
+	}
+	if final {
+		delete(store.subscribers, session.ID)
+	}
+}
+
+// getSessionStatus returns the session. Once it is verified, it sets the session token cookie if cookie is not empty
+// and the browser has the sign-in cookie of the session.
+func getSessionStatus(store *SessionStore, cookie string) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		sessionID := c.Query("sessionId")
+		if sessionID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "sessionId is required",
+			})
+			return
+		}
+		session, ok := store.Get(sessionID)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": errSessionNotFound.Error(),
+			})
+			return
+		}
+		nonce, _ := c.Cookie(sessionNonceCookie + session.ID)
+		if cookie != "" && session.Token != "" && session.signedInWith(nonce) {
+			maxAge := int(time.Until(session.UpdatedAt.Add(store.ttl)).Seconds())
+			secure := strings.HasPrefix(config.Verifier.Host, "https://")
+			c.SetSameSite(http.SameSiteLaxMode)
+			c.SetCookie(cookie, session.Token, maxAge, "/", "", secure, true)
+			c.SetCookie(sessionNonceCookie+session.ID, "", -1, "/api/v1/status", "", secure, true)
+		}
+		c.IndentedJSON(http.StatusOK, session)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// streamSessionStatus sends the session as a server-sent event on every status change until it is finished.
+func streamSessionStatus(store *SessionStore) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		sessionID := c.Query("sessionId")
+		updates, cancel, err := store.Subscribe(sessionID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		defer cancel()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		timeout := time.After(statusStreamTimeout)
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case session, ok := <-updates:
+				if !ok {
+					return false
+				}
+				data, err := json.Marshal(session)
+				if err != nil {
+					return false
+				}
+				fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+				return !session.isFinal()
+			case <-timeout:
+				return false
+			case <-c.Request.Context().Done():
+				return false
+			}
+		})
+	}
+	return gin.HandlerFunc(fn)
+}
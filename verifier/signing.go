@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSigningKeyPath = "./data/signing.key"
+	// requestSignatureHeader holds the base64url Ed25519 signature of the body of a verification request
+	requestSignatureHeader = "X-Request-Signature"
+	// deepLinkPrefix opens the wallet of the holder with the URL of the request
+	deepLinkPrefix = "iden3comm://?request_uri="
+
+	defaultQRScale = 8
+	maxQRScale     = 32
+)
+
+// RequestSigner signs what the verifier sends to holders, so that the holder can show who is asking. The public key
+// is published at /api/v1/verifier.
+type RequestSigner struct {
+	key ed25519.PrivateKey
+	// referenceKey is derived from the signing key and authenticates the request references of the QR codes
+	referenceKey []byte
+}
+
+func newRequestSigner(seed []byte) *RequestSigner {
+	referenceKey := sha256.Sum256(append([]byte("verifier request reference"), seed...))
+	return &RequestSigner{key: ed25519.NewKeyFromSeed(seed), referenceKey: referenceKey[:]}
+}
+
+// LoadRequestSigner reads the hex encoded Ed25519 seed at path, a missing file is created with a new key.
+func LoadRequestSigner(path string) (*RequestSigner, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		seed := make([]byte, ed25519.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(path, []byte(hex.EncodeToString(seed)), 0600)
+		if err != nil {
+			return nil, err
+		}
+		return newRequestSigner(seed), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key %s", path)
+	}
+	return newRequestSigner(seed), nil
+}
+
+func (signer *RequestSigner) Sign(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(ed25519.Sign(signer.key, data))
+}
+
+func (signer *RequestSigner) Verify(data []byte, signature string) bool {
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(signer.key.Public().(ed25519.PublicKey), data, sig)
+}
+
+func (signer *RequestSigner) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(signer.key.Public().(ed25519.PublicKey))
+}
+
+// getVerifierInfo tells holders who the verifier is and which key signs its requests.
+func getVerifierInfo(signer *RequestSigner) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"id":   config.Verifier.ID,
+			"host": config.Verifier.Host,
+			"signingKey": gin.H{
+				"alg":       "EdDSA",
+				"crv":       "Ed25519",
+				"publicKey": signer.PublicKey(),
+			},
+		})
+	}
+	return gin.HandlerFunc(fn)
+}
+
+// A request reference is base64url(session UUID | expiry in unix seconds | truncated HMAC-SHA256 of both). It only
+// comes back to this verifier, so a MAC keeps it short enough for a small QR code.
+const (
+	referenceIDSize  = 16
+	referenceMACSize = 16
+	referenceSize    = referenceIDSize + 4 + referenceMACSize
+)
+
+var (
+	errInvalidReference = errors.New("invalid request reference")
+	errReferenceExpired = errors.New("request reference expired")
+)
+
+// SignReference returns the reference of the session, valid until expiresAt.
+func (signer *RequestSigner) SignReference(sessionID string, expiresAt time.Time) (string, error) {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return "", err
+	}
+	ref := make([]byte, referenceIDSize+4, referenceSize)
+	copy(ref, id[:])
+	binary.BigEndian.PutUint32(ref[referenceIDSize:], uint32(expiresAt.Unix()))
+	return base64.RawURLEncoding.EncodeToString(append(ref, signer.referenceMAC(ref)...)), nil
+}
+
+// VerifyReference checks the MAC and the expiry of a reference and returns its session ID.
+func (signer *RequestSigner) VerifyReference(reference string) (string, error) {
+	ref, err := base64.RawURLEncoding.DecodeString(reference)
+	if err != nil || len(ref) != referenceSize {
+		return "", errInvalidReference
+	}
+	body := ref[:referenceIDSize+4]
+	if !hmac.Equal(ref[referenceIDSize+4:], signer.referenceMAC(body)) {
+		return "", errInvalidReference
+	}
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint32(body[referenceIDSize:])), 0)
+	if time.Now().After(expiresAt) {
+		return "", errReferenceExpired
+	}
+	id, err := uuid.FromBytes(body[:referenceIDSize])
+	if err != nil {
+		return "", errInvalidReference
+	}
+	return id.String(), nil
+}
+
+func (signer *RequestSigner) referenceMAC(body []byte) []byte {
+	mac := hmac.New(sha256.New, signer.referenceKey)
+	mac.Write(body)
+	return mac.Sum(nil)[:referenceMACSize]
+}
+
+func referenceErrorStatus(err error) int {
+	if errors.Is(err, errReferenceExpired) {
+		return http.StatusGone
+	}
+	return http.StatusBadRequest
+}
+
+// sessionLinks returns the URL of the request of the session with its signed reference, and the iden3comm deep link
+// that wallets open.
+func sessionLinks(session Session) (string, string, error) {
+	ref, err := requestSigner.SignReference(session.ID, session.ExpiresAt)
+	if err != nil {
+		return "", "", err
+	}
+	requestURI := fmt.Sprintf("%s/api/v1/requestVerificationQuery?ref=%s", config.Verifier.Host, ref)
+	return requestURI, deepLinkPrefix + url.QueryEscape(requestURI), nil
+}
+
+// getSessionQR renders the deep link of a session that was not scanned yet as an SVG or PNG QR code.
+func getSessionQR() gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		session, ok := sessionStore.Get(c.Query("sessionId"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": errSessionNotFound.Error(),
+			})
+			return
+		}
+		if session.Status != SessionCreated {
+			c.JSON(http.StatusGone, gin.H{
+				"error": errSessionState.Error(),
+			})
+			return
+		}
+		_, deepLink, err := sessionLinks(session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		code, err := EncodeQR(deepLink)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		switch c.DefaultQuery("format", "svg") {
+		case "svg":
+			c.Data(http.StatusOK, "image/svg+xml", code.SVG())
+		case "png":
+			scale, err := strconv.Atoi(c.DefaultQuery("scale", strconv.Itoa(defaultQRScale)))
+			if err != nil || scale < 1 || scale > maxQRScale {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("scale must be between 1 and %d", maxQRScale),
+				})
+				return
+			}
+			image, err := code.PNG(scale)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			c.Data(http.StatusOK, "image/png", image)
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "format must be svg or png",
+			})
+		}
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -3,6 +3,7 @@ const base_url = window.location.origin+window.location.pathname;
 window.onload = () => {
     const qrBtnEl = document.querySelector('.btn-qr');
     const qrCodeEl = document.querySelector('#qrcode');
+    const statusEl = document.querySelector('#status');
 
     qrBtnEl.addEventListener('click', (e) => {
         makeDisabled(qrBtnEl, false)
@@ -16,21 +17,76 @@ window.onload = () => {
                 handleDisplay(qrBtnEl, false);
                 return id
             })
+            .then(id => followStatus(statusEl, id))
             .catch(err => console.log(err));
 
     });
 
 }
 
+const statusMessages = {
+    created: 'Scan the QR code with your wallet',
+    scanned: 'Waiting for the proof from your wallet',
+    verifying: 'Checking the proof from your wallet',
+    verified: 'Verification successful',
+    failed: 'Verification failed',
+    expired: 'The QR code expired, reload the page to try again',
+}
+
+// Show the status of the session, with server-sent events when the browser supports them and polling otherwise
+function followStatus(el, sessionId) {
+    const show = (session) => {
+        el.textContent = statusMessages[session.status] || session.status
+        if (session.result && session.result.failure) {
+            el.textContent += ' (' + session.result.failure + ' check)'
+        }
+        el.className = 'status status-' + session.status
+        handleDisplay(el, true)
+    }
+    const isFinal = (session) => ['verified', 'failed', 'expired'].includes(session.status)
+    const query = '?sessionId=' + encodeURIComponent(sessionId)
+
+    if (window.EventSource) {
+        const source = new EventSource(base_url + 'api/v1/status/stream' + query)
+        source.addEventListener('status', (e) => {
+            const session = JSON.parse(e.data)
+            show(session)
+            if (isFinal(session)) {
+                source.close()
+                // The session token cookie is only set by the status endpoint, for the browser that signed in
+                if (session.status === 'verified') {
+                    fetch(base_url + 'api/v1/status' + query, { credentials: 'same-origin' })
+                }
+            }
+        })
+        source.onerror = () => source.close()
+        return
+    }
+
+    const poll = () => fetch(base_url + 'api/v1/status' + query)
+        .then(r => r.json())
+        .then(session => {
+            show(session)
+            if (!isFinal(session)) {
+                setTimeout(poll, 2000)
+            }
+        })
+        .catch(err => console.log(err));
+    poll()
+}
+
+// Show the QR code rendered by the verifier, and the deep link for wallets on the same device
 function makeQr(el, data) {
-    return new QRCode(el, {
-        text: JSON.stringify(data),
-        width: 300,
-        height: 300,
-        colorDark: "#000",
-        colorLight: "#e9e9e9",
-        correctLevel: QRCode.CorrectLevel.H
-    });
+    const img = document.createElement('img')
+    img.src = data.qr
+    img.alt = 'QR code of the verification request'
+    img.width = 300
+    img.height = 300
+    const link = document.createElement('a')
+    link.href = data.deepLink
+    link.className = 'wallet-link'
+    link.textContent = 'Open in wallet'
+    el.replaceChildren(img, link)
 }
 
 function handleDisplay(el, needShow, display = 'block') {
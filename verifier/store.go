@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StoreRecord holds the fields a Store manages for each of its records.
+type StoreRecord struct {
+	ID        string    `json:"id"`
+	Disabled  bool      `json:"disabled"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (record *StoreRecord) stored() *StoreRecord {
+	return record
+}
+
+// storable is a pointer to a record of type T that embeds a StoreRecord and is changed by request bodies of type B.
+type storable[T any, B any] interface {
+	*T
+	stored() *StoreRecord
+	// apply sets the fields of the record, Disabled included, from the body
+	apply(body B)
+}
+
+// Store keeps records in memory with numeric IDs and writes them to a JSON file on every change. Records are
+// disabled instead of deleted, so that sessions that already use them keep working.
+type Store[T any, B any, P storable[T, B]] struct {
+	path     string
+	notFound error
+
+	mu      sync.RWMutex
+	records map[string]*T
+}
+
+// LoadStore reads the records saved at path. A missing file starts the store with the default records.
+func LoadStore[T any, B any, P storable[T, B]](path string, notFound error, defaults []T) (*Store[T, B, P], error) {
+	store := &Store[T, B, P]{path: path, notFound: notFound, records: make(map[string]*T)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if len(defaults) == 0 {
+			return store, nil
+		}
+		for i := range defaults {
+			record := defaults[i]
+			store.records[P(&record).stored().ID] = &record
+		}
+		return store, store.save()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []T
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store %s: %w", path, err)
+	}
+	for i := range records {
+		store.records[P(&records[i]).stored().ID] = &records[i]
+	}
+	return store, nil
+}
+
+func (store *Store[T, B, P]) Get(id string) (T, bool) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	record, ok := store.records[id]
+	if !ok {
+		var empty T
+		return empty, false
+	}
+	return *record, true
+}
+
+// List returns the records ordered by ID, disabled ones only when includeDisabled is set.
+func (store *Store[T, B, P]) List(includeDisabled bool) []T {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	records := make([]T, 0, len(store.records))
+	for _, record := range store.records {
+		if includeDisabled || !P(record).stored().Disabled {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		idA, idB := P(&records[i]).stored().ID, P(&records[j]).stored().ID
+		a, errA := strconv.Atoi(idA)
+		b, errB := strconv.Atoi(idB)
+		if errA != nil || errB != nil {
+			return idA < idB
+		}
+		return a < b
+	})
+	return records
+}
+
+func (store *Store[T, B, P]) Create(body B) (T, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	now := time.Now().UTC()
+	record := new(T)
+	P(record).apply(body)
+	stored := P(record).stored()
+	stored.ID = store.nextID()
+	stored.CreatedAt = now
+	stored.UpdatedAt = now
+	store.records[stored.ID] = record
+	err := store.save()
+	if err != nil {
+		delete(store.records, stored.ID)
+		var empty T
+		return empty, err
+	}
+	return *record, nil
+}
+
+func (store *Store[T, B, P]) Update(id string, body B) (T, error) {
+	return store.change(id, func(record P) {
+		record.apply(body)
+	})
+}
+
+// Disable keeps the record for sessions that already use it, but no new requests are made for it.
+func (store *Store[T, B, P]) Disable(id string) (T, error) {
+	return store.change(id, func(record P) {
+		record.stored().Disabled = true
+	})
+}
+
+// change applies a change to a record and saves it, the record is restored when it can't be saved.
+func (store *Store[T, B, P]) change(id string, change func(record P)) (T, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var empty T
+	record, ok := store.records[id]
+	if !ok {
+		return empty, store.notFound
+	}
+	previous := *record
+	change(P(record))
+	P(record).stored().UpdatedAt = time.Now().UTC()
+	err := store.save()
+	if err != nil {
+		*record = previous
+		return empty, err
+	}
+	return *record, nil
+}
+
+// nextID returns the ID after the highest numeric ID in use.
+func (store *Store[T, B, P]) nextID() string {
+	max := 0
+	for id := range store.records {
+		if n, err := strconv.Atoi(id); err == nil && n > max {
+			max = n
+		}
+	}
+	return strconv.Itoa(max + 1)
+}
+
+// save writes the records to a temporary file that then replaces the store file, so a crash never leaves half a file.
+func (store *Store[T, B, P]) save() error {
+	records := make([]T, 0, len(store.records))
+	for _, record := range store.records {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return P(&records[i]).stored().CreatedAt.Before(P(&records[j]).stored().CreatedAt)
+	})
+	data, err := json.MarshalIndent(records, "", "	")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(store.path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := store.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, store.path)
+}
+
+// bodyValidator rejects request bodies that can't be stored.
+type bodyValidator[B any] func(ctx context.Context, body B) error
+
+func createRecord[T any, B any, P storable[T, B]](store *Store[T, B, P], validate bodyValidator[B]) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var body B
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		if err := validate(c.Request.Context(), body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		record, err := store.Create(body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusCreated, record)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func listRecords[T any, B any, P storable[T, B]](store *Store[T, B, P]) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		includeDisabled := c.Query("disabled") == "true"
+		c.IndentedJSON(http.StatusOK, store.List(includeDisabled))
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func getRecord[T any, B any, P storable[T, B]](store *Store[T, B, P]) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		record, ok := store.Get(c.Param("id"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": store.notFound.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, record)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func updateRecord[T any, B any, P storable[T, B]](store *Store[T, B, P], validate bodyValidator[B]) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		var body B
+		if err := c.BindJSON(&body); err != nil {
+			return
+		}
+		if _, ok := store.Get(c.Param("id")); !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": store.notFound.Error()})
+			return
+		}
+		if err := validate(c.Request.Context(), body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		record, err := store.Update(c.Param("id"), body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, record)
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func disableRecord[T any, B any, P storable[T, B]](store *Store[T, B, P]) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		record, err := store.Disable(c.Param("id"))
+		if errors.Is(err, store.notFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, record)
+	}
+	return gin.HandlerFunc(fn)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/iden3/go-circuits"
+	"github.com/iden3/go-iden3-auth/loaders"
+	"github.com/iden3/go-iden3-auth/proofs"
+	"github.com/iden3/go-iden3-auth/pubsignals"
+	"github.com/iden3/iden3comm/protocol"
+)
+
+// Parts of a response that can fail verification
+const (
+	FailureRequest = "request"
+	FailureProof   = "proof"
+	FailureState   = "state"
+	FailureQuery   = "query"
+)
+
+// VerificationResult is the outcome of the verification of a holder's response. ScopeID is the proof request that
+// failed, zero when the failure is not about a single proof. Scopes has the outcome of every proof request.
+type VerificationResult struct {
+	Verified bool          `json:"verified"`
+	Failure  string        `json:"failure,omitempty"`
+	ScopeID  uint32        `json:"scopeId,omitempty"`
+	Reason   string        `json:"reason,omitempty"`
+	Scopes   []ScopeResult `json:"scopes,omitempty"`
+}
+
+// ScopeResult is the outcome of the proof of one proof request.
+type ScopeResult struct {
+	ScopeID  uint32 `json:"scopeId"`
+	Verified bool   `json:"verified"`
+	Failure  string `json:"failure,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type verificationError struct {
+	failure string
+	scopeID uint32
+	err     error
+}
+
+func (e *verificationError) Error() string {
+	if e.scopeID != 0 {
+		return fmt.Sprintf("%s check of scope %d failed: %s", e.failure, e.scopeID, e.err)
+	}
+	return fmt.Sprintf("%s check failed: %s", e.failure, e.err)
+}
+
+func (e *verificationError) Unwrap() error {
+	return e.err
+}
+
+func failVerification(failure string, scopeID uint32, err error) error {
+	return &verificationError{failure: failure, scopeID: scopeID, err: err}
+}
+
+// resultFromError turns a verification error into the result returned to the holder and stored in the session.
+func resultFromError(err error) VerificationResult {
+	if err == nil {
+		return VerificationResult{Verified: true}
+	}
+	var verr *verificationError
+	if errors.As(err, &verr) {
+		return VerificationResult{Failure: verr.failure, ScopeID: verr.scopeID, Reason: verr.err.Error()}
+	}
+	return VerificationResult{Failure: FailureRequest, Reason: err.Error()}
+}
+
+// verifyScopes checks every proof the request asked for, like VerifyAuthResponse of go-iden3-auth, but tells which
+// proofs failed and whether the proof itself, the identity states or the query were wrong. At least required proofs
+// must verify, all of them when required is zero.
+func verifyScopes(ctx context.Context, response protocol.AuthorizationResponseMessage, request protocol.AuthorizationRequestMessage, required int, keyLoader loaders.VerificationKeyLoader, resolver pubsignals.StateResolver) ([]ScopeResult, error) {
+	if request.Body.Message != response.Body.Message {
+		return nil, failVerification(FailureRequest, 0, errors.New("message of the request is not in the response"))
+	}
+	if required <= 0 || required > len(request.Body.Scope) {
+		required = len(request.Body.Scope)
+	}
+
+	results := make([]ScopeResult, 0, len(request.Body.Scope))
+	var firstFailure error
+	passed := 0
+	for _, proofRequest := range request.Body.Scope {
+		err := verifyScope(ctx, response, proofRequest, keyLoader, resolver)
+		result := resultFromError(err)
+		results = append(results, ScopeResult{
+			ScopeID:  proofRequest.ID,
+			Verified: result.Verified,
+			Failure:  result.Failure,
+			Reason:   result.Reason,
+		})
+		if err != nil {
+			if firstFailure == nil {
+				firstFailure = err
+			}
+			continue
+		}
+		passed++
+	}
+	if passed < required {
+		return results, firstFailure
+	}
+	return results, nil
+}
+
+// verifyScope checks the proof of one proof request of the response.
+func verifyScope(ctx context.Context, response protocol.AuthorizationResponseMessage, proofRequest protocol.ZeroKnowledgeProofRequest, keyLoader loaders.VerificationKeyLoader, resolver pubsignals.StateResolver) error {
+	scopeID := proofRequest.ID
+	var proofResponse *protocol.ZeroKnowledgeProofResponse
+	for i := range response.Body.Scope {
+		if response.Body.Scope[i].ID == scopeID {
+			proofResponse = &response.Body.Scope[i]
+			break
+		}
+	}
+	if proofResponse == nil {
+		return failVerification(FailureRequest, scopeID, errors.New("no proof for the scope"))
+	}
+	if proofRequest.CircuitID != proofResponse.CircuitID {
+		return failVerification(FailureRequest, scopeID, fmt.Errorf("proof uses circuit %s instead of %s", proofResponse.CircuitID, proofRequest.CircuitID))
+	}
+
+	verificationKey, err := keyLoader.Load(circuits.CircuitID(proofResponse.CircuitID))
+	if err != nil {
+		return failVerification(FailureRequest, scopeID, fmt.Errorf("can't load verification key of circuit %s: %w", proofResponse.CircuitID, err))
+	}
+	err = proofs.VerifyProof(*proofResponse, verificationKey)
+	if err != nil {
+		return failVerification(FailureProof, scopeID, err)
+	}
+
+	circuitVerifier, err := pubsignals.GetVerifier(circuits.CircuitID(proofResponse.CircuitID))
+	if err != nil {
+		return failVerification(FailureRequest, scopeID, err)
+	}
+	signalsBytes, err := json.Marshal(proofResponse.PubSignals)
+	if err != nil {
+		return failVerification(FailureProof, scopeID, err)
+	}
+	err = circuitVerifier.PubSignalsUnmarshal(signalsBytes)
+	if err != nil {
+		return failVerification(FailureProof, scopeID, err)
+	}
+
+	queryBytes, err := json.Marshal(proofRequest.Rules["query"])
+	if err != nil {
+		return failVerification(FailureRequest, scopeID, err)
+	}
+	var query pubsignals.Query
+	err = json.Unmarshal(queryBytes, &query)
+	if err != nil {
+		return failVerification(FailureRequest, scopeID, err)
+	}
+
+	// The proof must be made by the sender for this scope
+	err = circuitVerifier.VerifyIDOwnership(response.From, big.NewInt(int64(scopeID)))
+	if err != nil {
+		return failVerification(FailureProof, scopeID, err)
+	}
+	err = circuitVerifier.VerifyQuery(ctx, query, schemaLoader)
+	if err != nil {
+		return failVerification(FailureQuery, scopeID, err)
+	}
+	err = circuitVerifier.VerifyStates(ctx, resolver)
+	if err != nil {
+		return failVerification(FailureState, scopeID, err)
+	}
+	return nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	EventSessionExpired = "session.expired"
+
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+
+	defaultWebhookOutboxPath  = "./data/webhooks.json"
+	defaultWebhookMaxAttempts = 8
+	defaultWebhookBackoff     = 5 * time.Second
+	defaultWebhookMaxBackoff  = time.Hour
+	// webhookRetention is how long delivered and failed deliveries stay in the outbox for the delivery log
+	webhookRetention = 7 * 24 * time.Hour
+	// webhookPollInterval is how often the dispatcher looks for deliveries that are due
+	webhookPollInterval = time.Second
+
+	// Headers of the webhook requests. The signature is "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+	webhookEventHeader     = "X-Verifier-Event"
+	webhookDeliveryHeader  = "X-Verifier-Delivery"
+	webhookSignatureHeader = "X-Verifier-Signature"
+)
+
+var errDeliveryNotFound = errors.New("delivery not found")
+
+// WebhookEndpoint is a URL that receives the events it subscribed to, all of them when Events is empty.
+type WebhookEndpoint struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret"`
+	Events []string `yaml:"events"`
+}
+
+func (endpoint WebhookEndpoint) subscribed(event string) bool {
+	if len(endpoint.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range endpoint.Events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event sent to one endpoint, with the state of its attempts.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	URL            string          `json:"url"`
+	Event          string          `json:"event"`
+	SessionID      string          `json:"sessionId"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"lastStatusCode,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+
+	// secret is taken from the endpoint configuration, it is not written to the outbox
+	secret string
+}
+
+// WebhookDispatcher delivers session events to the webhook endpoints. Deliveries are kept in an outbox file so that
+// the pending ones are retried after a restart, with an exponential backoff between the attempts.
+type WebhookDispatcher struct {
+	path        string
+	endpoints   []WebhookEndpoint
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	client      *http.Client
+
+	mu         sync.Mutex
+	deliveries map[string]*WebhookDelivery
+	wake       chan struct{}
+}
+
+// LoadWebhookDispatcher reads the outbox of the dispatcher. A missing file starts with an empty outbox.
+func LoadWebhookDispatcher(config *Config) (*WebhookDispatcher, error) {
+	settings := config.Webhooks
+	dispatcher := &WebhookDispatcher{
+		path:        settings.Outbox,
+		endpoints:   settings.Endpoints,
+		maxAttempts: settings.MaxAttempts,
+		backoff:     settings.Backoff,
+		maxBackoff:  settings.MaxBackoff,
+		client:      &http.Client{Timeout: settings.Timeout},
+		deliveries:  make(map[string]*WebhookDelivery),
+		wake:        make(chan struct{}, 1),
+	}
+	if dispatcher.path == "" {
+		dispatcher.path = defaultWebhookOutboxPath
+	}
+	if dispatcher.maxAttempts <= 0 {
+		dispatcher.maxAttempts = defaultWebhookMaxAttempts
+	}
+	if dispatcher.backoff <= 0 {
+		dispatcher.backoff = defaultWebhookBackoff
+	}
+	if dispatcher.maxBackoff <= 0 {
+		dispatcher.maxBackoff = defaultWebhookMaxBackoff
+	}
+	if settings.Timeout <= 0 {
+		dispatcher.client.Timeout = defaultWebhookTimeout
+	}
+	for _, endpoint := range dispatcher.endpoints {
+		if endpoint.URL == "" || endpoint.Secret == "" {
+			return nil, errors.New("webhook endpoints need a url and a secret")
+		}
+	}
+
+	data, err := ioutil.ReadFile(dispatcher.path)
+	if os.IsNotExist(err) {
+		return dispatcher, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []WebhookDelivery
+	err = json.Unmarshal(data, &deliveries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook outbox %s: %w", dispatcher.path, err)
+	}
+	for i := range deliveries {
+		dispatcher.deliveries[deliveries[i].ID] = &deliveries[i]
+	}
+	return dispatcher, nil
+}
+
+// Publish queues the event for every endpoint subscribed to it.
+func (dispatcher *WebhookDispatcher) Publish(event *VerificationEvent) {
+	for _, endpoint := range dispatcher.endpoints {
+		if endpoint.subscribed(event.Event) {
+			dispatcher.Enqueue(endpoint, event)
+		}
+	}
+}
+
+// Enqueue queues the event for one endpoint, whether it subscribed to it or not.
+func (dispatcher *WebhookDispatcher) Enqueue(endpoint WebhookEndpoint, event *VerificationEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode %s event of session %s: %s", event.Event, event.SessionID, err)
+		return
+	}
+	now := time.Now().UTC()
+	delivery := &WebhookDelivery{
+		ID:            uuid.New().String(),
+		URL:           endpoint.URL,
+		Event:         event.Event,
+		SessionID:     event.SessionID,
+		Payload:       payload,
+		Status:        DeliveryPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		secret:        endpoint.Secret,
+	}
+
+	dispatcher.mu.Lock()
+	dispatcher.deliveries[delivery.ID] = delivery
+	err = dispatcher.save()
+	dispatcher.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to save webhook outbox: %s", err)
+	}
+	select {
+	case dispatcher.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (dispatcher *WebhookDispatcher) Get(id string) (WebhookDelivery, bool) {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	delivery, ok := dispatcher.deliveries[id]
+	if !ok {
+		return WebhookDelivery{}, false
+	}
+	return *delivery, true
+}
+
+// List returns the deliveries, newest first, filtered by status and session when they are not empty.
+func (dispatcher *WebhookDispatcher) List(status, sessionID string) []WebhookDelivery {
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	deliveries := make([]WebhookDelivery, 0, len(dispatcher.deliveries))
+	for _, delivery := range dispatcher.deliveries {
+		if status != "" && delivery.Status != status || sessionID != "" && delivery.SessionID != sessionID {
+			continue
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	return deliveries
+}
+
+// Start sends the due deliveries in the background until the process exits.
+func (dispatcher *WebhookDispatcher) Start() {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+		for {
+			dispatcher.deliverDue()
+			select {
+			case <-ticker.C:
+			case <-dispatcher.wake:
+			}
+		}
+	}()
+}
+
+// deliverDue makes one attempt for every pending delivery that is due, and removes the finished deliveries that
+// are older than the retention.
+func (dispatcher *WebhookDispatcher) deliverDue() {
+	now := time.Now().UTC()
+	var due []WebhookDelivery
+	pruned := false
+	dispatcher.mu.Lock()
+	for id, delivery := range dispatcher.deliveries {
+		if delivery.Status != DeliveryPending {
+			if now.Sub(delivery.UpdatedAt) > webhookRetention {
+				delete(dispatcher.deliveries, id)
+				pruned = true
+			}
+			continue
+		}
+		if !delivery.NextAttemptAt.After(now) {
+			due = append(due, *delivery)
+		}
+	}
+	dispatcher.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool { return due[i].CreatedAt.Before(due[j].CreatedAt) })
+
+	for _, delivery := range due {
+		statusCode, err := dispatcher.send(delivery)
+		dispatcher.mu.Lock()
+		dispatcher.record(dispatcher.deliveries[delivery.ID], statusCode, err)
+		dispatcher.mu.Unlock()
+	}
+
+	// The outbox only changes when an attempt was made or a delivery was removed
+	if len(due) == 0 && !pruned {
+		return
+	}
+	dispatcher.mu.Lock()
+	err := dispatcher.save()
+	dispatcher.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to save webhook outbox: %s", err)
+	}
+}
+
+// send posts the payload of the delivery, signed with the secret of its endpoint.
+func (dispatcher *WebhookDispatcher) send(delivery WebhookDelivery) (int, error) {
+	secret := delivery.secret
+	if secret == "" {
+		secret = dispatcher.secretOf(delivery.URL)
+	}
+	if secret == "" {
+		return 0, errors.New("no secret configured for the webhook url")
+	}
+	request, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(webhookEventHeader, delivery.Event)
+	request.Header.Set(webhookDeliveryHeader, delivery.ID)
+	request.Header.Set(webhookSignatureHeader, signWebhook([]byte(secret), time.Now().Unix(), delivery.Payload))
+
+	resp, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt and schedules the next one. The caller holds the lock.
+func (dispatcher *WebhookDispatcher) record(delivery *WebhookDelivery, statusCode int, err error) {
+	if delivery == nil {
+		return
+	}
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.UpdatedAt = now
+	if err == nil {
+		delivery.Status = DeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+		return
+	}
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= dispatcher.maxAttempts {
+		delivery.Status = DeliveryFailed
+		log.Printf("Giving up webhook %s of session %s after %d attempts: %s", delivery.Event, delivery.SessionID, delivery.Attempts, err)
+		return
+	}
+	delivery.NextAttemptAt = now.Add(dispatcher.backoffAfter(delivery.Attempts))
+}
+
+// backoffAfter doubles the delay after every failed attempt, up to the maximum backoff.
+func (dispatcher *WebhookDispatcher) backoffAfter(attempts int) time.Duration {
+	delay := dispatcher.backoff
+	for i := 1; i < attempts && delay < dispatcher.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > dispatcher.maxBackoff {
+		delay = dispatcher.maxBackoff
+	}
+	return delay
+}
+
+// secretOf finds the secret of a delivery loaded from the outbox, which does not keep the secrets.
+func (dispatcher *WebhookDispatcher) secretOf(url string) string {
+	for _, endpoint := range dispatcher.endpoints {
+		if endpoint.URL == url {
+			return endpoint.Secret
+		}
+	}
+	for _, action := range config.Actions {
+		if action.Type == ActionWebhook && action.URL == url {
+			return action.Secret
+		}
+	}
+	return ""
+}
+
+// save writes the outbox to a temporary file that then replaces the outbox file. The caller holds the lock.
+func (dispatcher *WebhookDispatcher) save() error {
+	deliveries := make([]WebhookDelivery, 0, len(dispatcher.deliveries))
+	for _, delivery := range dispatcher.deliveries {
+		deliveries = append(deliveries, *delivery)
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt) })
+	data, err := json.MarshalIndent(deliveries, "", "	")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dispatcher.path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := dispatcher.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dispatcher.path)
+}
+
+// signWebhook signs the timestamp and the body, receivers recompute the HMAC and reject old timestamps.
+func signWebhook(secret []byte, timestamp int64, body []byte) string {
+	t := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newExpiredEvent builds the event of a session that expired before the holder answered.
+func newExpiredEvent(session Session) *VerificationEvent {
+	event := newVerificationEvent(session, VerificationResult{Reason: errSessionExpired.Error()})
+	event.Event = EventSessionExpired
+	return event
+}
+
+func listWebhookDeliveries(dispatcher *WebhookDispatcher) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		c.IndentedJSON(http.StatusOK, dispatcher.List(c.Query("status"), c.Query("sessionId")))
+	}
+	return gin.HandlerFunc(fn)
+}
+
+func getWebhookDelivery(dispatcher *WebhookDispatcher) gin.HandlerFunc {
+	fn := func(c *gin.Context) {
+		delivery, ok := dispatcher.Get(c.Param("id"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": errDeliveryNotFound.Error()})
+			return
+		}
+		c.IndentedJSON(http.StatusOK, delivery)
+	}
+	return gin.HandlerFunc(fn)
+}